@@ -1,228 +1,1012 @@
 package main
 
 import (
+	"errors"
 	"flag"
 	"fmt"
+	"math"
 	"net"
 	"net/rpc"
+	"path/filepath"
 	"strings"
+	"sync"
 	"time"
 
 	"uk.ac.bris.cs/gameoflife/stubs"
 	"uk.ac.bris.cs/gameoflife/util"
 )
 
-var keyPresses chan rune = make(chan rune)
 var workers []*rpc.Client = make([]*rpc.Client, 0)
+var workerIPs []string = make([]string, 0)
 var stopRunning chan bool = make(chan bool)
 
-type Boundary struct {
-	Top    int
-	Bottom int
+// workers can register and be dropped at any time, so the pool is guarded by a lock
+// each worker can only work on one session at a time, so busy keeps track of the ones in use
+var workersLock sync.Mutex
+var busy map[*rpc.Client]bool = make(map[*rpc.Client]bool)
+
+// how long a worker gets to finish a turn or answer a ping before we decide it's died
+var workerTimeout time.Duration = 10 * time.Second
+
+// how long a controller gets to answer a call before it's detached, so one that's stopped can't hold the run up
+var controllerTimeout time.Duration = 5 * time.Second
+
+// how often the broker pulls the whole world from the workers, this is how far back we go if a worker dies
+var snapshotInterval time.Duration = 10 * time.Second
+
+// how often each session is checkpointed to disk so it can be resumed after a restart, 0 turns this off
+// checkpoints are also written whenever a controller asks for one
+var checkpointInterval time.Duration = 5 * time.Minute
+var checkpointDir string = "checkpoints"
+
+// how many frames a second the live view gets if the controller doesn't say
+const defaultFPS = 30
+
+// the slowest and fastest + and - can set the turns a second to, going faster than the fastest takes the limit off
+// while waiting for the next turn the broker sleeps for at most throttleSleep, so it still answers keypresses
+const minTurnRate = 1
+const maxTurnRate = 100000
+const throttleSleep = 50 * time.Millisecond
+
+// function to split the world between the given workers and start each of them off on its strip
+func initialiseWorkers(req stubs.WorldData, clients []*rpc.Client, ips []string) error {
+	heights := util.SegmentHeights(req.Height, len(clients))
+	segmentStart := 0
+	for i, client := range clients {
+		// workers swap halo rows with the workers either side of them, wrapping around at the top and bottom
+		// edges if the topology does, otherwise the workers at the edges have nobody to swap with there
+		above := ips[(len(clients)+i-1)%len(clients)]
+		below := ips[(i+1)%len(clients)]
+		if !req.Topology.WrapsVertically() {
+			if i == 0 {
+				above = ""
+			}
+			if i == len(clients)-1 {
+				below = ""
+			}
+		}
+		initialisationData := stubs.WorldDataBounded{Data: req, Top: segmentStart, Bottom: segmentStart + heights[i], Above: above, Below: below}
+		err := client.Call(stubs.InitialiseWorker, initialisationData, &stubs.Report{})
+		fmt.Println("Initialising workers", err)
+		if err != nil {
+			return err
+		}
+
+		segmentStart += heights[i]
+	}
+	return nil
 }
 
-func decodeCells(cells []util.Cell) []util.Cell {
-	newCells := make([]util.Cell, 0)
-	for _, cell := range cells {
-		newCells = append(newCells, util.Cell{X: cell.X - 1, Y: cell.Y - 1})
+// function to work out how long to leave between frames of the live view
+func frameIntervalFor(fps int) time.Duration {
+	if fps <= 0 {
+		fps = defaultFPS
 	}
-	return newCells
+	return time.Second / time.Duration(fps)
 }
 
-func getSegmenttHeights(height, threads int) []int {
-	segmentHeight := height / threads
-	spare := height - (segmentHeight * threads)
-	heights := make([]int, 0)
-	for i := 0; i < threads; i++ {
-		currentHeight := segmentHeight
-		if spare > 0 {
-			currentHeight++
-			spare--
+// function to pull every worker's strip and put the whole world back together
+func fetchWorld(clients []*rpc.Client, width, height, depth int) (util.PackedWorld, error) {
+	world := util.PackedWorld{Width: width, Height: height, Depth: depth, Bits: make([]byte, util.RowBytes(width, depth)*height)}
+	calls := make([]*rpc.Call, len(clients))
+	for i, client := range clients {
+		calls[i] = client.Go(stubs.GetStrip, stubs.Report{}, &stubs.WorldResponse{}, make(chan *rpc.Call, 1))
+	}
+
+	deadline := time.After(workerTimeout)
+	for _, call := range calls {
+		select {
+		case <-call.Done:
+			if call.Error != nil {
+				return world, call.Error
+			}
+			strip := call.Reply.(*stubs.WorldResponse)
+			copy(world.Bits[strip.Top*util.RowBytes(width, depth):], strip.World.Bits)
+		case <-deadline:
+			return world, errors.New("timed out fetching the world")
 		}
-		heights = append(heights, currentHeight)
 	}
-	return heights
+	return world, nil
 }
 
-func worldFromLiveCells(liveCells []util.Cell, height, width int) [][]byte {
-	world := make([][]byte, height)
-	for i := range world {
-		world[i] = make([]byte, width)
+// function to add a worker to the pool, if it's already in there (e.g. it restarted) the old connection is replaced
+func addWorker(client *rpc.Client, ip string) {
+	workersLock.Lock()
+	defer workersLock.Unlock()
+	for i, workerIP := range workerIPs {
+		if workerIP == ip {
+			workers[i].Close()
+			workers[i] = client
+			return
+		}
 	}
+	workers = append(workers, client)
+	workerIPs = append(workerIPs, ip)
+}
 
-	for _, cell := range liveCells {
-		world[cell.Y][cell.X] = 255
+// function to remove a dead worker from the pool, so it isn't handed to any later runs either
+func removeWorker(client *rpc.Client) {
+	workersLock.Lock()
+	defer workersLock.Unlock()
+	for i, worker := range workers {
+		if worker == client {
+			workers = append(workers[:i], workers[i+1:]...)
+			workerIPs = append(workerIPs[:i], workerIPs[i+1:]...)
+			delete(busy, client)
+			break
+		}
 	}
-	return world
+	client.Close()
 }
 
-func getLiveCells(world [][]byte) []util.Cell {
-	liveCells := make([]util.Cell, 0)
-	for y, row := range world {
-		for x, status := range row {
-			if status == 255 {
-				liveCells = append(liveCells, util.Cell{X: x, Y: y})
-			}
+// function to take n free workers out of the pool for a session, returns false if there aren't enough
+func claimWorkers(n int) ([]*rpc.Client, []string, bool) {
+	workersLock.Lock()
+	defer workersLock.Unlock()
+	clients := make([]*rpc.Client, 0)
+	ips := make([]string, 0)
+	for i, worker := range workers {
+		if len(clients) == n {
+			break
+		}
+		if !busy[worker] {
+			clients = append(clients, worker)
+			ips = append(ips, workerIPs[i])
+		}
+	}
+	if len(clients) < n {
+		return nil, nil, false
+	}
+	for _, client := range clients {
+		busy[client] = true
+	}
+	return clients, ips, true
+}
+
+// function to put a session's workers back in the pool once it's done with them
+func releaseWorkers(clients []*rpc.Client) {
+	workersLock.Lock()
+	defer workersLock.Unlock()
+	for _, client := range clients {
+		delete(busy, client)
+	}
+}
+
+// function to check if a worker is still responding
+func pingWorker(client *rpc.Client) bool {
+	call := client.Go(stubs.Ping, stubs.Report{}, &stubs.Report{}, make(chan *rpc.Call, 1))
+	select {
+	case <-call.Done:
+		return call.Error == nil
+	case <-time.After(workerTimeout):
+		return false
+	}
+}
+
+// function to ping every worker in a run, and return only the ones that are still responding
+func findSurvivors(clients []*rpc.Client, ips []string) ([]*rpc.Client, []string) {
+	survivors := make([]*rpc.Client, 0)
+	survivorIPs := make([]string, 0)
+	for i, client := range clients {
+		if pingWorker(client) {
+			survivors = append(survivors, client)
+			survivorIPs = append(survivorIPs, ips[i])
+			continue
+		}
+		fmt.Println("Lost worker", ips[i])
+		removeWorker(client)
+	}
+	return survivors, survivorIPs
+}
+
+// function to periodically ping every worker in the pool, dropping any that have stopped responding
+func heartbeat(interval time.Duration) {
+	for range time.Tick(interval) {
+		workersLock.Lock()
+		clients := append([]*rpc.Client{}, workers...)
+		ips := append([]string{}, workerIPs...)
+		workersLock.Unlock()
+
+		findSurvivors(clients, ips)
+	}
+}
+
+// function to hand the given world out between whichever workers are still alive
+// keeps going until a set of workers starts up successfully, or there are none left
+func reassignWorkers(req stubs.WorldData, clients []*rpc.Client, ips []string) ([]*rpc.Client, []string, error) {
+	for {
+		clients, ips = findSurvivors(clients, ips)
+		if len(clients) == 0 {
+			return clients, ips, errors.New("no workers left to run the simulation")
+		}
+		fmt.Println("Reassigning world between", len(clients), "workers")
+		if initialiseWorkers(req, clients, ips) == nil {
+			return clients, ips, nil
 		}
 	}
-	return liveCells
 }
 
 type GolBroker struct{}
 
+// rpc function for a worker to join the pool, the broker dials back to the address it gives
+func (g *GolBroker) RegisterWorker(req stubs.WorkerInfo, res *stubs.Report) (err error) {
+	worker, err := rpc.Dial("tcp", req.WorkerIP)
+	fmt.Println("Registering worker", req.WorkerIP, err)
+	if err != nil {
+		return
+	}
+	addWorker(worker, req.WorkerIP)
+	return
+}
+
+// the final state of a run, sent to whichever controller is attached when it ends
+type runResult struct {
+	response stubs.WorldResponse
+	err      error
+}
+
+// a controller asking to attach to a running simulation
+type attachment struct {
+	request stubs.AttachRequest
+	done    chan runResult
+}
+
+// a simulation running on the broker, each one has its own workers and its own controller which can come and go
+// result is set if the run ended while no controller was attached, and handed to the next controller that attaches
+type session struct {
+	id          int
+	running     bool
+	keyPresses  chan rune
+	attachments chan attachment
+	edits       chan stubs.EditRequest
+	cancel      chan bool
+	finished    chan bool
+	result      *runResult
+}
+
+var sessions map[int]*session = make(map[int]*session)
+var sessionsLock sync.Mutex
+var lastSession int
+
+// function to find a session, 0 means whichever session there is, as long as there's only one
+// must be called with sessionsLock held
+func findSession(id int) (*session, error) {
+	if id == 0 && len(sessions) == 1 {
+		for _, s := range sessions {
+			return s, nil
+		}
+	}
+	s, ok := sessions[id]
+	if !ok {
+		return nil, errors.New(fmt.Sprint("no session ", id))
+	}
+	return s, nil
+}
+
+// function to mark a run as over, and hand the result to the attached controller if there is one
+// if nobody's attached the session is kept until someone attaches to collect the result
+// returns true if that was the last session running
+func endRun(s *session, result runResult, done chan runResult) bool {
+	sessionsLock.Lock()
+	defer sessionsLock.Unlock()
+	s.running = false
+	close(s.finished)
+	if done != nil {
+		done <- result
+		delete(sessions, s.id)
+	} else {
+		s.result = &result
+	}
+	for _, other := range sessions {
+		if other.running {
+			return false
+		}
+	}
+	return true
+}
+
+// rpc function to hand out a new session ID, the controller passes it in to MainGol, KeyPress and Attach
+func (g *GolBroker) NewSession(req stubs.Report, res *stubs.SessionInfo) (err error) {
+	sessionsLock.Lock()
+	defer sessionsLock.Unlock()
+	lastSession++
+	res.Session = lastSession
+	return
+}
+
+// rpc function for a controller to change cells in its simulation, returns once the run has taken the edit
+func (g *GolBroker) Edit(req stubs.EditRequest, res *stubs.Report) (err error) {
+	sessionsLock.Lock()
+	s, err := findSession(req.Session)
+	sessionsLock.Unlock()
+	if err != nil {
+		return
+	}
+	if len(req.Cells) != len(req.Levels) {
+		return errors.New("edit needs a level for every cell")
+	}
+	select {
+	case s.edits <- req:
+	case <-s.finished:
+		err = errors.New(fmt.Sprint("session ", s.id, " has finished"))
+	}
+	return
+}
+
 func (g *GolBroker) KeyPress(req stubs.KeyPress, res *stubs.Report) (err error) {
-	keyPresses <- req.Key
+	sessionsLock.Lock()
+	s, err := findSession(req.Session)
+	sessionsLock.Unlock()
+	if err != nil {
+		return
+	}
+	select {
+	case s.keyPresses <- req.Key:
+	case <-s.finished:
+		err = errors.New(fmt.Sprint("session ", s.id, " has finished"))
+	}
 	return
 }
 
+// rpc function for a controller to stop its run without writing anything out, used when the controller's
+// context is cancelled, the workers are let go and the broker carries on serving other sessions
+func (g *GolBroker) Cancel(req stubs.SessionInfo, res *stubs.Report) (err error) {
+	sessionsLock.Lock()
+	s, err := findSession(req.Session)
+	sessionsLock.Unlock()
+	if err != nil {
+		return
+	}
+	select {
+	case s.cancel <- true:
+	case <-s.finished:
+	}
+	return
+}
+
+// rpc function for a controller to attach to a running simulation, returns once the run is over, or the
+// controller detaches. If the run has already finished while detached then its result is returned straight away
+func (g *GolBroker) Attach(req stubs.AttachRequest, res *stubs.WorldResponse) (err error) {
+	sessionsLock.Lock()
+	s, err := findSession(req.Session)
+	if err != nil {
+		sessionsLock.Unlock()
+		return
+	}
+	if !s.running {
+		result := s.result
+		delete(sessions, s.id)
+		sessionsLock.Unlock()
+		*res = result.response
+		return result.err
+	}
+	sessionsLock.Unlock()
+
+	done := make(chan runResult, 1)
+	select {
+	case s.attachments <- attachment{request: req, done: done}:
+	// the run ended before we got to it, so pick up its result instead
+	case <-s.finished:
+		return g.Attach(req, res)
+	}
+	result := <-done
+	*res = result.response
+	return result.err
+}
+
 func (g *GolBroker) MainGol(req stubs.WorldData, res *stubs.WorldResponse) (err error) {
 	fmt.Println("Gotcalled")
 	fmt.Println(req.Threads)
-	if req.Threads <= len(workers) {
-		controller, _ := rpc.Dial("tcp", req.ClientIP)
-
-		heights := getSegmenttHeights(req.Height, req.Threads)
-		segmentStart := 0
-		responses := make([]stubs.WorldResponse, 0)
-
-		doneChannels := make([]chan *rpc.Call, 0)
-		boundaries := make([]Boundary, 0)
-		for i := 0; i < req.Threads; i++ {
-			initialisationData := stubs.WorldDataBounded{Data: req, Top: segmentStart, Bottom: segmentStart + heights[i]}
-			err = workers[i].Call(stubs.InitialiseWorker, initialisationData, &stubs.Report{})
-			fmt.Println("Initialising workers", err)
-			responses = append(responses, stubs.WorldResponse{})
-			doneChannels = append(doneChannels, make(chan *rpc.Call, 2))
-			boundaries = append(boundaries, Boundary{Top: (req.Height + segmentStart - 1) % req.Height, Bottom: (segmentStart + heights[i]) % req.Height})
-
-			segmentStart += heights[i]
-		}
-
-		world := req.World
-		turn := 0
-		liveCells := getLiveCells(world)
-		ticker := time.NewTicker(2 * time.Second)
-
-		pause := false
-		halt := false
-		close := false
-
-		for turn < req.Turn {
-			if halt || close {
-				break
-			}
-
-			if !pause {
-				select {
-				case <-ticker.C:
-					controller.Call(stubs.LiveCellReport, stubs.LiveCellsCount{LiveCells: len(liveCells), Turn: turn}, &stubs.Report{})
-				case keyPress := <-keyPresses:
-					switch keyPress {
-					// s needs to make a PGM, so send a response object with current status
-					case 's':
-						controller.Call(stubs.KeyPressResponse, stubs.WorldResponse{LiveCells: liveCells, Turn: turn}, &stubs.Report{})
-					// q means that the client has shut, so indicate that the GOL needs to halt
-					case 'q':
-						halt = true
-						for _, worker := range workers {
-							worker.Call(stubs.WorkerKeyPress, stubs.KeyPress{Key: 'q'}, &stubs.Report{})
-						}
-					// k means that the GOL needs to end, and a new PGM needs to be made,
-					// update the response object, indicate that it's okay to continue, and that the program needs to close
-					case 'k':
-						controller.Call(stubs.KeyPressResponse, stubs.WorldResponse{LiveCells: liveCells, Turn: turn}, &stubs.Report{})
-						close = true
-						for _, worker := range workers {
-							worker.Call(stubs.WorkerKeyPress, stubs.KeyPress{Key: 'k'}, &stubs.Report{})
-						}
-						// p means pause, and the client needs to report the turn, so update the turn, indicate
-					// that processing has been paused, then indicate that it's okay for the client to continue
-					case 'p':
-						controller.Call(stubs.KeyPressResponse, stubs.WorldResponse{LiveCells: liveCells, Turn: turn}, &stubs.Report{})
-						fmt.Println("Called back")
-						pause = true
-					}
-				default:
-					liveCellsTemp := make([]util.Cell, 0)
-					for i := 0; i < req.Threads; i++ {
-						workers[i].Go(stubs.TakeTurn, stubs.BoundaryUpdate{Top: world[boundaries[i].Top], Bottom: world[boundaries[i].Bottom], Turn: turn}, &responses[i], doneChannels[i])
-					}
+	// a controller resuming a checkpoint passes on whatever was in it, which might not be a rule or topology we know
+	if !req.Rule.Valid() || !req.Topology.Valid() {
+		return errors.New(fmt.Sprint("can't run rule ", req.Rule, " on topology ", req.Topology))
+	}
+	sessionsLock.Lock()
+	if _, exists := sessions[req.Session]; exists {
+		sessionsLock.Unlock()
+		return errors.New(fmt.Sprint("session ", req.Session, " is already running"))
+	}
 
-					for i := 0; i < req.Threads; i++ {
-						<-doneChannels[i]
-						var response []util.Cell
-						if responses[i].Liveness == 1 {
-							response = decodeCells(responses[i].LiveCells)
-						} else {
-							response = make([]util.Cell, 0)
-						}
-						liveCellsTemp = append(liveCellsTemp, response...)
-					}
+	// the workers being used for this run, this shrinks if any of them die
+	// every worker needs at least one row of its own, so a world with fewer rows than that gets fewer workers
+	threads := req.Threads
+	if threads > req.Height {
+		threads = req.Height
+	}
+	runWorkers, runIPs, enoughWorkers := claimWorkers(threads)
+	if !enoughWorkers {
+		sessionsLock.Unlock()
+		return errors.New(fmt.Sprint("need ", threads, " free workers, not enough are registered"))
+	}
+	s := &session{
+		id:          req.Session,
+		running:     true,
+		keyPresses:  make(chan rune),
+		attachments: make(chan attachment),
+		edits:       make(chan stubs.EditRequest),
+		cancel:      make(chan bool),
+		finished:    make(chan bool),
+	}
+	sessions[s.id] = s
+	sessionsLock.Unlock()
 
-					world = worldFromLiveCells(liveCellsTemp, req.Height, req.Width)
+	// the run goes on in the background, so it can outlive this controller
+	done := make(chan runResult, 1)
+	go runGol(s, req, runWorkers, runIPs, done)
+	result := <-done
+	*res = result.response
+	return result.err
+}
 
-					liveCells = liveCellsTemp
+// main broker loop, hands out turns to the workers and reports back to whichever controller is attached
+func runGol(s *session, req stubs.WorldData, runWorkers []*rpc.Client, runIPs []string, done chan runResult) {
+	var err error
 
-					turn++
+	// the world itself lives on the workers, the broker only keeps the last snapshot it pulled from them,
+	// which is what gets sent to the controller, and what we restart from if a worker dies
+	// a resumed run starts part of the way through
+	turn := req.StartTurn
+	aliveCount := req.World.AliveCount()
+	snapshot := req.World
+	snapshotTurn := req.StartTurn
+	ticker := time.NewTicker(2 * time.Second)
+	snapshotTicker := time.NewTicker(snapshotInterval)
+	var checkpointTicker *time.Ticker
+	var checkpointTick <-chan time.Time
+	if checkpointInterval > 0 {
+		checkpointTicker = time.NewTicker(checkpointInterval)
+		checkpointTick = checkpointTicker.C
+	}
+
+	// the controller is nil while detached, done is nil once nobody is waiting on the result
+	controller, dialErr := rpc.Dial("tcp", req.ClientIP)
+	if dialErr != nil {
+		controller = nil
+	}
+
+	// the live view is sent a frame at most fps times a second, and we don't wait for it to be shown
+	// frameCall is the last frame, if the controller's still busy with it when the next one's due then it's fallen
+	// behind, so it skips ahead to the whole world at whatever turn we're on once it's caught up
+	frameInterval := frameIntervalFor(req.FPS)
+	var lastFrame time.Time
+	var frameCall *rpc.Call
+	frameTurn := -1
+	resync := true
+	// the alive cells counts aren't waited for either, countCall is the last one, which was sent at countSent
+	var countCall *rpc.Call
+	var countSent time.Time
+
+	// lets the controller go with the current state, the run carries on without it
+	detach := func() {
+		if controller != nil {
+			controller.Close()
+			controller = nil
+		}
+		frameCall = nil
+		countCall = nil
+		if done != nil {
+			done <- runResult{response: stubs.WorldResponse{World: snapshot, Turn: snapshotTurn, Detached: true}}
+			done = nil
+		}
+	}
+	// calls back to the controller, if it's gone away or doesn't answer in time then detach it
+	report := func(method string, args interface{}) {
+		if controller == nil {
+			return
+		}
+		call := controller.Go(method, args, &stubs.Report{}, make(chan *rpc.Call, 1))
+		select {
+		case <-call.Done:
+			if call.Error != nil {
+				fmt.Println("Lost controller", call.Error)
+				detach()
+			}
+		case <-time.After(controllerTimeout):
+			fmt.Println("Controller stopped responding")
+			detach()
+		}
+	}
+	// checks on a call to the controller that we didn't wait for, returns true once it's done
+	// if it didn't get through, or the controller's had it too long, then the controller's detached
+	callDone := func(call *rpc.Call, sent time.Time) bool {
+		select {
+		case <-call.Done:
+			if call.Error != nil {
+				fmt.Println("Lost controller", call.Error)
+				detach()
+			}
+			return true
+		default:
+			if time.Since(sent) > controllerTimeout {
+				fmt.Println("Controller stopped responding")
+				detach()
+				return true
+			}
+			return false
+		}
+	}
+	// waits for the controller to finish with the last frame, so that a frame sent after it can't overtake it
+	// frames only send what's changed since the one before, so one that arrived late would undo those cells
+	awaitFrame := func() {
+		if frameCall == nil {
+			return
+		}
+		select {
+		case <-frameCall.Done:
+			if frameCall.Error != nil {
+				fmt.Println("Lost controller", frameCall.Error)
+				detach()
+			}
+		case <-time.After(controllerTimeout - time.Since(lastFrame)):
+			fmt.Println("Controller stopped responding")
+			detach()
+		}
+		frameCall = nil
+	}
+	// checks if the controller's finished with the last frame
+	frameReady := func() bool {
+		if frameCall == nil || callDone(frameCall, lastFrame) {
+			frameCall = nil
+			return true
+		}
+		return false
+	}
+	// sends the alive cells count without waiting, it's skipped if the controller hasn't taken the last one yet
+	reportCount := func() {
+		if controller == nil || (countCall != nil && !callDone(countCall, countSent)) {
+			return
+		}
+		// checking on the last one can have detached the controller
+		if controller == nil {
+			return
+		}
+		countCall = controller.Go(stubs.LiveCellReport, stubs.LiveCellsCount{Session: s.id, LiveCells: aliveCount,
+			Turn: turn, Workers: len(runWorkers)}, &stubs.Report{}, make(chan *rpc.Call, 1))
+		countSent = time.Now()
+	}
+	// pulls the current world from the workers, returns false if any of them didn't answer
+	takeSnapshot := func() bool {
+		world, fetchErr := fetchWorld(runWorkers, req.Width, req.Height, req.Rule.Depth())
+		if fetchErr != nil {
+			fmt.Println("Snapshot failed", fetchErr)
+			return false
+		}
+		snapshot = world
+		snapshotTurn = turn
+		return true
+	}
+	// sends the live view the last snapshot, the workers don't know it's been sent so the next frame is a resync
+	showSnapshot := func() {
+		awaitFrame()
+		if controller != nil {
+			report(stubs.TurnReport, stubs.TurnChanges{Session: s.id, Turn: snapshotTurn, Resync: true, World: snapshot})
+			resync = true
+		}
+	}
+	// sends each worker the cells in an edit that are in its strip, then pulls the world back so the snapshot,
+	// the alive count and the live view all include it, returns false if any of the workers didn't answer
+	applyEdit := func(edit stubs.EditRequest) bool {
+		top := 0
+		for i, height := range util.SegmentHeights(req.Height, len(runWorkers)) {
+			stripEdit := stubs.EditRequest{Session: s.id}
+			for j, cell := range edit.Cells {
+				if cell.Y >= top && cell.Y < top+height {
+					stripEdit.Cells = append(stripEdit.Cells, cell)
+					stripEdit.Levels = append(stripEdit.Levels, edit.Levels[j])
+				}
+			}
+			top += height
+			if len(stripEdit.Cells) == 0 {
+				continue
+			}
+			call := runWorkers[i].Go(stubs.WorkerEdit, stripEdit, &stubs.Report{}, make(chan *rpc.Call, 1))
+			select {
+			case <-call.Done:
+				if call.Error != nil {
+					fmt.Println("Edit failed", call.Error)
+					return false
+				}
+			case <-time.After(workerTimeout):
+				fmt.Println("Edit timed out")
+				return false
+			}
+		}
+		if !takeSnapshot() {
+			return false
+		}
+		aliveCount = snapshot.AliveCount()
+		showSnapshot()
+		return true
+	}
+	// writes the last snapshot to disk, along with everything needed to resume from it
+	// session IDs start again from 1 when the broker restarts, so the name has when the run started in it too,
+	// otherwise a new run would overwrite the checkpoint an old one needs to be resumed from
+	started := time.Now().Format("20060102-150405")
+	saveCheckpoint := func() util.Checkpoint {
+		checkpoint := util.Checkpoint{World: snapshot, Turn: snapshotTurn, Turns: req.Turn, Rule: req.Rule, Topology: req.Topology}
+		path := filepath.Join(checkpointDir, fmt.Sprint(req.Width, "x", req.Height, "-", started, "-", s.id, ".checkpoint"))
+		fmt.Println("Checkpointing turn", snapshotTurn, "to", path, checkpoint.Save(path))
+		return checkpoint
+	}
+
+	// turns are run as fast as the workers can go unless turnRate is set, in turns a second
+	// how fast it's going is measured between alive cells reports, from rateTurn and rateTime
+	turnRate := 0.0
+	var lastTurn time.Time
+	measuredRate := 0.0
+	rateTurn, rateTime := turn, time.Now()
+	// + doubles the turns a second, and - halves it, the first - halves however fast it's going now
+	throttle := func(key rune) {
+		switch {
+		case key == '-' && turnRate == 0:
+			if measuredRate == 0 {
+				measuredRate = float64(turn-rateTurn) / time.Since(rateTime).Seconds()
+			}
+			turnRate = math.Max(measuredRate/2, minTurnRate)
+		case key == '-':
+			turnRate = math.Max(turnRate/2, minTurnRate)
+		case key == '+' && turnRate > 0:
+			turnRate *= 2
+			if turnRate > maxTurnRate {
+				turnRate = 0
+			}
+		}
+		fmt.Println("Turns per second limited to", turnRate)
+	}
+
+	if initialiseWorkers(req, runWorkers, runIPs) != nil {
+		runWorkers, runIPs, err = reassignWorkers(req, runWorkers, runIPs)
+	}
+
+	responses := make([]stubs.TurnResponse, len(runWorkers))
+	doneChannels := make([]chan *rpc.Call, len(runWorkers))
+	for i := range doneChannels {
+		doneChannels[i] = make(chan *rpc.Call, 2)
+	}
+
+	// hands the last snapshot out between whichever workers are still alive, and carries on from there
+	restartFromSnapshot := func() {
+		recoveryData := req
+		recoveryData.World = snapshot
+		runWorkers, runIPs, err = reassignWorkers(recoveryData, runWorkers, runIPs)
+		turn = snapshotTurn
+		aliveCount = snapshot.AliveCount()
+		// the new workers don't know what the live view last saw, and it's already seen turns past the snapshot
+		resync = true
+
+		// fresh responses so late replies from the failed turn can't be mistaken for new ones
+		responses = make([]stubs.TurnResponse, len(runWorkers))
+		doneChannels = make([]chan *rpc.Call, len(runWorkers))
+		for i := range doneChannels {
+			doneChannels[i] = make(chan *rpc.Call, 2)
+		}
+	}
+	// r starts again from the initial world, the live view and the controller are told which turn that is
+	restart := func() {
+		snapshot = req.World
+		snapshotTurn = req.StartTurn
+		restartFromSnapshot()
+		measuredRate = 0
+		rateTurn, rateTime = turn, time.Now()
+		showSnapshot()
+		report(stubs.KeyPressResponse, stubs.WorldResponse{Session: s.id, World: snapshot, Turn: snapshotTurn})
+	}
+
+	pause := false
+	close := false
+	cancelled := false
+	// n runs a single turn while paused, step is set until it's done, then we pause again
+	step := false
+
+	// lets a new controller take over, it's told where the run is up to and whether it's paused
+	// returns false if the workers didn't answer when we pulled the world from them
+	attach := func(a attachment) bool {
+		// a new controller can only take over once the old one has gone, while paused nothing's being sent to
+		// the old one, so check it's still there first
+		if controller != nil {
+			report(stubs.LiveCellReport, stubs.LiveCellsCount{Session: s.id, LiveCells: aliveCount, Turn: turn,
+				Workers: len(runWorkers)})
+		}
+		if controller != nil || done != nil {
+			a.done <- runResult{err: errors.New("simulation already has a controller attached")}
+			return true
+		}
+		if a.request.Width != req.Width || a.request.Height != req.Height {
+			a.done <- runResult{err: errors.New(fmt.Sprint("simulation is ", req.Width, "x", req.Height))}
+			return true
+		}
+		if controller, dialErr = rpc.Dial("tcp", a.request.ClientIP); dialErr != nil {
+			controller = nil
+			a.done <- runResult{err: dialErr}
+			return true
+		}
+		fmt.Println("Controller attached")
+		done = a.done
+		frameInterval = frameIntervalFor(a.request.FPS)
+		resync = true
+		ok := takeSnapshot()
+		report(stubs.AttachedReport, stubs.AttachState{Session: s.id, World: snapshot, Turn: snapshotTurn, Turns: req.Turn,
+			Paused: pause})
+		return ok
+	}
+
+	// once all the turns are done we still need a snapshot of the final world before finishing
+	for snapshotTurn < req.Turn && err == nil {
+		if close || cancelled {
+			break
+		}
+
+		if !pause {
+			// set if a worker has stopped responding, so we need to start again from the last snapshot
+			failed := false
+
+			select {
+			case <-ticker.C:
+				reportCount()
+				measuredRate = float64(turn-rateTurn) / time.Since(rateTime).Seconds()
+				rateTurn, rateTime = turn, time.Now()
+			case <-snapshotTicker.C:
+				failed = !takeSnapshot()
+			case <-s.cancel:
+				fmt.Println("Cancelled")
+				cancelled = true
+			case edit := <-s.edits:
+				failed = !applyEdit(edit)
+			case <-checkpointTick:
+				failed = !takeSnapshot()
+				if !failed {
+					saveCheckpoint()
 				}
-			} else {
-				// only need to handle keypresses in this state (I think), so no need for a select
-				keyPress := <-keyPresses
-				// only need to handle p and k in this state, for k behave as normal, for p unpause by setting pause
-				// to false
+			case a := <-s.attachments:
+				failed = !attach(a)
+			case keyPress := <-s.keyPresses:
 				switch keyPress {
+				// s needs to make a PGM, so send a response object with current status, w is the same but for RLE
+				case 's', 'w':
+					failed = !takeSnapshot()
+					report(stubs.KeyPressResponse, stubs.WorldResponse{Session: s.id, World: snapshot, Turn: snapshotTurn})
+				// c asks for a checkpoint, which is written here and also sent to the controller to keep
+				// if the snapshot fails then the last one we have is still consistent, so that gets saved instead
+				case 'c':
+					failed = !takeSnapshot()
+					checkpoint := saveCheckpoint()
+					report(stubs.CheckpointReport, stubs.CheckpointResponse{Session: s.id, Checkpoint: checkpoint})
+				// q means that the controller is leaving, so let it go with the current state and carry on without it
+				case 'q':
+					fmt.Println("Controller detached")
+					detach()
+				// k means that the GOL needs to end, and a new PGM needs to be made,
+				// update the response object, indicate that it's okay to continue, and that the program needs to close
+				// if the snapshot fails then the last one we have is still consistent, so that gets sent instead
 				case 'k':
-					controller.Call(stubs.KeyPressResponse, stubs.WorldResponse{LiveCells: liveCells, Turn: turn}, &stubs.Report{})
-					for _, worker := range workers {
+					takeSnapshot()
+					report(stubs.KeyPressResponse, stubs.WorldResponse{Session: s.id, World: snapshot, Turn: snapshotTurn})
+					close = true
+					for _, worker := range runWorkers {
 						worker.Call(stubs.WorkerKeyPress, stubs.KeyPress{Key: 'k'}, &stubs.Report{})
 					}
-					close = true
+					// p means pause, and the client needs to report the turn, so update the turn, indicate
+				// that processing has been paused, then indicate that it's okay for the client to continue
+				// the live view is brought up to date too, so it shows what any edits made while paused apply to
 				case 'p':
-					pause = false
+					report(stubs.KeyPressResponse, stubs.WorldResponse{Session: s.id, Turn: turn})
+					fmt.Println("Called back")
+					pause = true
+					if failed = !takeSnapshot(); !failed {
+						showSnapshot()
+					}
+				case 'r':
+					restart()
+				case '+', '-':
+					throttle(keyPress)
+				}
+			default:
+				if turn == req.Turn {
+					failed = !takeSnapshot()
+					break
+				}
+				// if the turns are being throttled then wait for the next one to be due, a bit at a time
+				if turnRate > 0 && !step {
+					wait := time.Until(lastTurn.Add(time.Duration(float64(time.Second) / turnRate)))
+					if wait > 0 {
+						if wait > throttleSleep {
+							wait = throttleSleep
+						}
+						time.Sleep(wait)
+						break
+					}
+				}
+				lastTurn = time.Now()
+
+				// a frame is collected along with this turn if one's due, and the controller's ready for it
+				// a single step gets the whole world once it's done instead
+				wantChanges := false
+				if controller != nil && time.Since(lastFrame) >= frameInterval && !step {
+					if frameReady() {
+						wantChanges = controller != nil
+					} else {
+						resync = true
+					}
+				}
+				wantResync := wantChanges && resync
+				// responses are cleared first, gob leaves fields alone if they're sent as zero, like an empty strip
+				for i, worker := range runWorkers {
+					responses[i] = stubs.TurnResponse{}
+					worker.Go(stubs.TakeTurn, stubs.TurnRequest{Turn: turn, Changes: wantChanges, Resync: wantResync}, &responses[i], doneChannels[i])
+				}
+
+				// any worker erroring or taking too long means the turn has failed
+				nextAliveCount := 0
+				deadline := time.After(workerTimeout)
+				for i := 0; i < len(runWorkers) && !failed; i++ {
+					select {
+					case call := <-doneChannels[i]:
+						if call.Error != nil {
+							fmt.Println("Turn failed", call.Error)
+							failed = true
+							break
+						}
+						nextAliveCount += responses[i].AliveCount
+					case <-deadline:
+						fmt.Println("Turn timed out")
+						failed = true
+					}
+				}
+
+				if !failed {
+					aliveCount = nextAliveCount
+					turn++
+					if wantChanges {
+						frame := stubs.TurnChanges{Session: s.id, Turn: turn, Changed: make([]uint32, 0), Resync: wantResync}
+						// strips are in order, so a resync is just each strip one after another
+						if wantResync {
+							frame.World = util.PackedWorld{Width: req.Width, Height: req.Height, Depth: req.Rule.Depth()}
+						}
+						for _, response := range responses {
+							frame.Changed = append(frame.Changed, response.Changed...)
+							frame.Levels = append(frame.Levels, response.Levels...)
+							frame.World.Bits = append(frame.World.Bits, response.Strip.Bits...)
+						}
+						frameCall = controller.Go(stubs.TurnReport, frame, &stubs.Report{}, make(chan *rpc.Call, 1))
+						lastFrame = time.Now()
+						frameTurn = turn
+						resync = false
+					}
+					// the step's done, so pause again and show the controller where it's got to
+					if step {
+						step = false
+						pause = true
+						if failed = !takeSnapshot(); !failed {
+							showSnapshot()
+						}
+						report(stubs.LiveCellReport, stubs.LiveCellsCount{Session: s.id, LiveCells: aliveCount, Turn: turn,
+							Workers: len(runWorkers)})
+						report(stubs.KeyPressResponse, stubs.WorldResponse{Session: s.id, World: snapshot, Turn: snapshotTurn})
+					}
 				}
 			}
+
+			// restart from the last snapshot, split between whoever's left
+			if failed {
+				restartFromSnapshot()
+			}
+		} else {
+			// only need to handle keypresses, edits and controllers attaching in this state, or the run being
+			// cancelled. If an edit or attaching fails then the turn after we unpause finds the worker that's died
+			var keyPress rune
+			select {
+			case keyPress = <-s.keyPresses:
+			case edit := <-s.edits:
+				applyEdit(edit)
+			case a := <-s.attachments:
+				attach(a)
+			case <-s.cancel:
+				fmt.Println("Cancelled")
+				cancelled = true
+			}
+			// for k and q behave as normal, for p unpause by setting pause to false, and for n unpause until the
+			// next turn's done. The snapshot is up to date while paused, so s, w and c can have it as it is
+			// the run stays paused after q, until a controller attaches and unpauses it
+			switch keyPress {
+			case 'q':
+				fmt.Println("Controller detached")
+				detach()
+			case 'k':
+				takeSnapshot()
+				report(stubs.KeyPressResponse, stubs.WorldResponse{Session: s.id, World: snapshot, Turn: snapshotTurn})
+				for _, worker := range runWorkers {
+					worker.Call(stubs.WorkerKeyPress, stubs.KeyPress{Key: 'k'}, &stubs.Report{})
+				}
+				close = true
+			// time spent paused doesn't count towards how fast it's going
+			case 'p':
+				pause = false
+				measuredRate = 0
+				rateTurn, rateTime = turn, time.Now()
+			case 'n':
+				pause = false
+				step = true
+			case 's', 'w':
+				report(stubs.KeyPressResponse, stubs.WorldResponse{Session: s.id, World: snapshot, Turn: snapshotTurn})
+			case 'c':
+				checkpoint := saveCheckpoint()
+				report(stubs.CheckpointReport, stubs.CheckpointResponse{Session: s.id, Checkpoint: checkpoint})
+			case 'r':
+				restart()
+			case '+', '-':
+				throttle(keyPress)
+			}
 		}
+	}
+	// the live view is left showing the final world, rather than whichever turn it was last sent
+	if snapshotTurn == req.Turn && err == nil && !(close || cancelled) && controller != nil {
+		awaitFrame()
+		if frameTurn != snapshotTurn {
+			report(stubs.TurnReport, stubs.TurnChanges{Session: s.id, Turn: snapshotTurn, Resync: true, World: snapshot})
+		}
+	}
+	ticker.Stop()
+	snapshotTicker.Stop()
+	if checkpointTicker != nil {
+		checkpointTicker.Stop()
+	}
+	if controller != nil {
 		controller.Close()
-		res.LiveCells = liveCells
-		res.Turn = turn
-		controller.Close()
-		if !(close || halt) {
-			for i := 0; i < req.Threads; i++ {
-				workers[i].Call(stubs.WorkerKeyPress, stubs.KeyPress{Key: 'q'}, &stubs.Report{})
-			}
+	}
+	if !(close || err != nil) {
+		for _, worker := range runWorkers {
+			worker.Call(stubs.WorkerKeyPress, stubs.KeyPress{Key: 'q'}, &stubs.Report{})
 		}
+	}
+	if cancelled {
+		err = errors.New(fmt.Sprint("session ", s.id, " was cancelled"))
+	}
 
-		if close {
-			fmt.Println("Closing")
-			stopRunning <- true
-		}
+	releaseWorkers(runWorkers)
+	lastRunning := endRun(s, runResult{response: stubs.WorldResponse{World: snapshot, Turn: snapshotTurn}, err: err}, done)
 
-		fmt.Println("Quitting...")
+	// k shuts the broker down too, unless someone else is still using it
+	if close && lastRunning {
+		fmt.Println("Closing")
+		stopRunning <- true
 	}
 
-	return
+	fmt.Println("Quitting...")
 }
 
 func main() {
 	pAddr := flag.String("port", "8050", "Port to listen on")
-	workerIPs := flag.String("workers", "127.0.0.1:8030", "comma separated (no spaces) of worker IPs")
+	workerList := flag.String("workers", "", "comma separated (no spaces) of worker IPs to start with, more can register with -broker")
+	flag.DurationVar(&workerTimeout, "timeout", 10*time.Second, "how long to wait for a worker before treating it as dead")
+	flag.DurationVar(&controllerTimeout, "controller-timeout", 5*time.Second, "how long to wait for a controller before detaching it")
+	flag.DurationVar(&snapshotInterval, "snapshot", 10*time.Second, "how often to pull the world from the workers, in case one of them dies")
+	flag.DurationVar(&checkpointInterval, "checkpoint", 5*time.Minute, "how often to checkpoint each simulation to disk, 0 turns this off")
+	flag.StringVar(&checkpointDir, "checkpoints", "checkpoints", "directory to write checkpoints to")
+	heartbeatInterval := flag.Duration("heartbeat", 2*time.Second, "how often to check that registered workers are still alive")
 	flag.Parse()
 
-	ips := strings.Split(*workerIPs, ",")
-
-	for _, ip := range ips {
-		worker, err := rpc.Dial("tcp", ip)
-		fmt.Println(err)
-		workers = append(workers, worker)
+	// workers that can't be reached are left out, rather than leaving a nil client in the pool
+	if *workerList != "" {
+		for _, ip := range strings.Split(*workerList, ",") {
+			worker, err := rpc.Dial("tcp", ip)
+			fmt.Println(err)
+			if err != nil {
+				continue
+			}
+			addWorker(worker, ip)
+		}
 	}
 
 	rpc.Register(&GolBroker{})
 	listener, _ := net.Listen("tcp", ":"+*pAddr)
 	go rpc.Accept(listener)
+	go heartbeat(*heartbeatInterval)
 	fmt.Println("ready to close")
 	<-stopRunning
+	workersLock.Lock()
 	for _, worker := range workers {
 		worker.Close()
 	}
+	workersLock.Unlock()
 	time.Sleep(2 * time.Second)
 }
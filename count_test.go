@@ -24,7 +24,7 @@ func TestAlive(t *testing.T) {
 	alive := readAliveCounts(p.ImageWidth, p.ImageHeight)
 	events := make(chan gol.Event)
 	keyPresses := make(chan rune, 2)
-	go gol.Run(p, events, keyPresses)
+	go gol.Run(p, events, keyPresses, nil)
 
 	implemented := make(chan bool)
 	go func() {
@@ -1,9 +1,13 @@
 package gol
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"net"
 	"net/rpc"
+	"path/filepath"
+	"sync"
 	"time"
 
 	"uk.ac.bris.cs/gameoflife/stubs"
@@ -17,34 +21,37 @@ type distributorChannels struct {
 	ioFilename chan<- string
 	ioOutput   chan<- uint8
 	ioInput    <-chan uint8
+	ioResult   <-chan error
 	keyPresses <-chan rune
+	edits      <-chan Edit
 }
 
-// channel for sending events from rpc calls to the main program loop
-var eventPasser = make(chan Event)
-var keyPressResponses = make(chan stubs.WorldResponse)
-var globalListener net.Listener
+// default addresses, used if they're not given in Params
+const defaultBrokerAddr = "127.0.0.1:8050"
+const defaultListenAddr = ":0"
+
+// how long to wait for the broker to stop a run when we've been cancelled
+const cancelTimeout = 5 * time.Second
 
 // distributor divides the work between workers and interacts with other goroutines.
 
 // function to get a list of live cells from a given world
 // goes through entire world, if a cell is live, it is added to the return list
 
-func acceptListener(listener *net.Listener) {
-	rpc.Accept(*listener)
-}
-
 // function to make a new 2D slice to represent a world given parameters and channels
-// sends to IO asking for the world, and gives it the file name, then reads in all cell values from the IO channel
-func makeWorld(p Params, c distributorChannels) [][]byte {
+// sends to IO asking for the world, and gives it the path of the file, then if IO could read it, reads in all
+// cell values from the IO channel
+func makeWorld(p Params, c distributorChannels) ([][]byte, error) {
 	world := make([][]byte, p.ImageHeight)
 	for i := range world {
 		world[i] = make([]byte, p.ImageWidth)
 	}
 
 	c.ioCommand <- ioInput
-	fileName := fmt.Sprint(p.ImageWidth, "x", p.ImageHeight)
-	c.ioFilename <- fileName
+	c.ioFilename <- inputPath(p)
+	if err := <-c.ioResult; err != nil {
+		return nil, err
+	}
 
 	for y, row := range world {
 		for x := range row {
@@ -52,159 +59,606 @@ func makeWorld(p Params, c distributorChannels) [][]byte {
 		}
 	}
 
-	return world
+	return world, nil
+}
+
+// function to load the checkpoint we're resuming from, and take the size, rule, topology and number of turns
+// from it
+func loadCheckpoint(p Params) (Params, util.Checkpoint, error) {
+	checkpoint, err := util.LoadCheckpoint(p.Resume)
+	if err != nil {
+		return p, checkpoint, err
+	}
+	p.ImageWidth = checkpoint.World.Width
+	p.ImageHeight = checkpoint.World.Height
+	p.Turns = checkpoint.Turns
+	p.Rule = checkpoint.Rule.String()
+	p.Topology = checkpoint.Topology.String()
+	return p, checkpoint, nil
+}
+
+// function to save a checkpoint the broker has sent us, named the same way as the PGMs
+// if it can't be saved then an ErrorEvent is sent, and the run carries on
+func writeCheckpoint(p Params, c distributorChannels, checkpoint util.Checkpoint) {
+	fileName := filepath.Join(outputDir(p), fmt.Sprint(checkpoint.World.Width, "x", checkpoint.World.Height, "x", checkpoint.Turn, ".checkpoint"))
+	err := checkpoint.Save(fileName)
+	if err != nil {
+		fmt.Println(err)
+		c.events <- ErrorEvent{CompletedTurns: checkpoint.Turn, Err: err}
+		return
+	}
+	fmt.Println("Checkpoint", fileName, "output done!")
+}
+
+// struct for RPC calls, each run has its own, and only takes calls for its own broker session
+// eventPasser sends events from rpc calls to the main program loop, it's buffered so the broker doesn't get
+// stuck reporting to us while we're waiting on it to take a keypress
+type StatusReceiver struct {
+	sessionLock       sync.Mutex
+	session           int
+	eventPasser       chan Event
+	keyPressResponses chan stubs.WorldResponse
+	attachedStates    chan stubs.AttachState
+	checkpoints       chan stubs.CheckpointResponse
+	turnReports       chan stubs.TurnChanges
+	stopped           chan bool
 }
 
-// struct for RPC calls
-type StatusReceiver struct{}
+// function to check that a call from the broker is for our session, if we attached without knowing which
+// session we wanted then we take on the first one that calls us
+func (s *StatusReceiver) checkSession(session int) error {
+	s.sessionLock.Lock()
+	defer s.sessionLock.Unlock()
+	if s.session == 0 {
+		s.session = session
+	}
+	if session != s.session {
+		return errors.New(fmt.Sprint("expected session ", s.session, ", got ", session))
+	}
+	return nil
+}
 
 // RPC function to allow the server to send live cell reports to the controller
 func (s *StatusReceiver) LiveCellReport(req stubs.LiveCellsCount, res *stubs.Report) (err error) {
-	eventPasser <- AliveCellsCount{CompletedTurns: req.Turn, CellsCount: req.LiveCells}
+	if err = s.checkSession(req.Session); err != nil {
+		return
+	}
+	s.eventPasser <- AliveCellsCount{CompletedTurns: req.Turn, CellsCount: req.LiveCells, Workers: req.Workers}
 	return
 }
 
 func (s *StatusReceiver) KeyPressResponse(req stubs.WorldResponse, res *stubs.Report) (err error) {
-	keyPressResponses <- req
+	if err = s.checkSession(req.Session); err != nil {
+		return
+	}
+	s.keyPressResponses <- req
+	return
+}
+
+// RPC function for the broker to tell us where the simulation is up to when we attach to it
+func (s *StatusReceiver) Attached(req stubs.AttachState, res *stubs.Report) (err error) {
+	if err = s.checkSession(req.Session); err != nil {
+		return
+	}
+	s.attachedStates <- req
+	return
+}
+
+// RPC function for the broker to send us a checkpoint we asked for
+func (s *StatusReceiver) CheckpointReport(req stubs.CheckpointResponse, res *stubs.Report) (err error) {
+	if err = s.checkSession(req.Session); err != nil {
+		return
+	}
+	s.checkpoints <- req
 	return
 }
 
-// function for accepting a listener without blocking
+// RPC function for the broker to send us the cells that changed in a turn, for the live view
+// once we've stopped the broker is told, rather than being left waiting on us
+func (s *StatusReceiver) TurnReport(req stubs.TurnChanges, res *stubs.Report) (err error) {
+	if err = s.checkSession(req.Session); err != nil {
+		return
+	}
+	select {
+	case s.turnReports <- req:
+	case <-s.stopped:
+		err = errors.New("controller has stopped")
+	}
+	return
+}
+
+// function to show a frame in the live view, sends events for every cell that's different to what's being shown
+// worlds with grey levels in them get shaded in, rather than flipped
+func showFrame(c distributorChannels, view, frame [][]byte, depth, turn int) {
+	for y, row := range frame {
+		for x, cell := range row {
+			if cell == view[y][x] {
+				continue
+			}
+			if depth == 8 {
+				c.events <- CellShaded{CompletedTurns: turn, Cell: util.Cell{X: x, Y: y}, Level: cell}
+			} else {
+				c.events <- CellFlipped{CompletedTurns: turn, Cell: util.Cell{X: x, Y: y}}
+			}
+			view[y][x] = cell
+		}
+	}
+}
+
+// function to show a turn's changes from the broker in the live view, or the whole world if it's sent us that
+func showTurn(c distributorChannels, view [][]byte, changes stubs.TurnChanges, depth int) {
+	if changes.Resync {
+		showFrame(c, view, changes.World.Unpack(), depth, changes.Turn)
+	} else {
+		width := len(view[0])
+		for i, index := range changes.Changed {
+			x, y := int(index)%width, int(index)/width
+			if depth == 8 {
+				view[y][x] = changes.Levels[i]
+				c.events <- CellShaded{CompletedTurns: changes.Turn, Cell: util.Cell{X: x, Y: y}, Level: view[y][x]}
+			} else {
+				view[y][x] = 255 - view[y][x]
+				c.events <- CellFlipped{CompletedTurns: changes.Turn, Cell: util.Cell{X: x, Y: y}}
+			}
+		}
+	}
+	c.events <- TurnComplete{CompletedTurns: changes.Turn}
+}
+
+// function for accepting connections without blocking, stops quietly once the listener is closed
+func acceptListener(server *rpc.Server, listener net.Listener) {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		go server.ServeConn(conn)
+	}
+}
+
+// function to start an RPC server for the broker to call back to, returns the listener so it can be closed
+// once the run is over, and the address the broker should use to reach it
+func startReceiver(p Params, receiver *StatusReceiver) (net.Listener, string, error) {
+	listenAddr := p.ListenAddr
+	if listenAddr == "" {
+		listenAddr = defaultListenAddr
+	}
+
+	server := rpc.NewServer()
+	server.Register(receiver)
+	listener, err := net.Listen("tcp", listenAddr)
+	if err != nil {
+		return nil, "", err
+	}
+	go acceptListener(server, listener)
+
+	// if we're not told what address to advertise, assume the broker is on this machine
+	callbackAddr := p.CallbackAddr
+	if callbackAddr == "" {
+		callbackAddr = fmt.Sprint("127.0.0.1:", listener.Addr().(*net.TCPAddr).Port)
+	}
+	return listener, callbackAddr, nil
+}
 
 // function to write a PGM file using IO channels, sends each cell down IO channel after initialising
-func writePgm(world [][]byte, c distributorChannels, fileName string) {
-	c.ioCommand <- ioOutput
+// then waits to hear if it worked, if it didn't then an ErrorEvent is sent, and the run carries on
+func writePgm(world [][]byte, c distributorChannels, fileName string, turn int) {
+	writeOutput(world, c, ioOutput, fileName, turn)
+}
+
+// function to write the world out with the given IO command, either ioOutput or ioOutputRLE
+func writeOutput(world [][]byte, c distributorChannels, command ioCommand, fileName string, turn int) {
+	c.ioCommand <- command
 	c.ioFilename <- fileName
 	for _, row := range world {
 		for _, cell := range row {
 			c.ioOutput <- cell
 		}
 	}
+	if err := <-c.ioResult; err != nil {
+		fmt.Println(err)
+		c.events <- ErrorEvent{CompletedTurns: turn, Err: err}
+	}
 }
 
-// function to create a full world from a list of live cells
-// makes an empty world, default that all cells are dead, then sets all live cells to alive value
-func worldFromLiveCells(liveCells []util.Cell, p Params) [][]byte {
-	world := make([][]byte, p.ImageHeight)
-	for i := range world {
-		world[i] = make([]byte, p.ImageWidth)
-	}
+// main distributor function, returns why the run didn't get to the end, if it didn't
+// a cancelled context stops the run on the broker too
+func distributor(ctx context.Context, p Params, c distributorChannels) error {
+	var client *rpc.Client
+	var listener net.Listener
+	var turn int
+
+	// function to finish up, whether or not the run got to the end, anything that went wrong is passed on as an
+	// ErrorEvent, unless the caller cancelled us, as then they already know
+	shutdown := func(err error) error {
+		if client != nil {
+			client.Close()
+		}
+		if listener != nil {
+			listener.Close()
+		}
+		if err != nil && ctx.Err() == nil {
+			fmt.Println(err)
+			c.events <- ErrorEvent{CompletedTurns: turn, Err: err}
+		}
 
-	for _, cell := range liveCells {
-		world[cell.Y][cell.X] = 255
-	}
-	return world
-}
+		// Make sure that the Io has finished any output before exiting.
+		c.ioCommand <- ioCheckIdle
+		<-c.ioIdle
 
-// main distributor function
-func distributor(p Params, c distributorChannels) {
+		c.events <- StateChange{turn, Quitting}
 
-	world := makeWorld(p, c)
+		// Close the channel to stop the SDL goroutine gracefully. Removing may cause deadlock.
+		close(c.events)
+		return err
+	}
 
-	turn := 0
+	// when attaching the world comes from the broker rather than from a file, and when resuming it comes
+	// from the checkpoint, if that can't be loaded then the run doesn't start, rather than starting over
+	var world [][]byte
+	if p.Resume != "" && !p.Attach {
+		resumed, checkpoint, err := loadCheckpoint(p)
+		if err != nil {
+			return shutdown(err)
+		}
+		fmt.Println("Resuming from turn", checkpoint.Turn)
+		p = resumed
+		world = checkpoint.World.Unpack()
+		turn = checkpoint.Turn
+	}
+	if world == nil && !p.Attach {
+		var err error
+		if world, err = makeWorld(p, c); err != nil {
+			return shutdown(err)
+		}
+	}
 
-	// setting up two-way RPC calls, server IP needs to be hardcoded
-	server := "127.0.0.1:8050"
-	client, _ := rpc.Dial("tcp", server)
+	// the rule and topology are checked by main, but anyone else calling Run with bad ones gets the normal
+	// game of life
+	rule := util.ConwayRule()
+	if p.Rule != "" {
+		parsedRule, err := util.ParseRule(p.Rule)
+		if err != nil {
+			fmt.Println(err, "- using", rule)
+		} else {
+			rule = parsedRule
+		}
+	}
+	topology := util.Torus
+	if p.Topology != "" {
+		parsedTopology, err := util.ParseTopology(p.Topology)
+		if err != nil {
+			fmt.Println(err, "- using", topology)
+		} else {
+			topology = parsedTopology
+		}
+	}
 
-	rpc.Register(&StatusReceiver{})
+	// setting up two-way RPC calls, giving up if the context is cancelled before the broker answers
+	server := p.BrokerAddr
+	if server == "" {
+		server = defaultBrokerAddr
+	}
+	conn, err := (&net.Dialer{}).DialContext(ctx, "tcp", server)
+	if err != nil {
+		return shutdown(err)
+	}
+	client = rpc.NewClient(conn)
+
+	// every call to the broker is tagged with our session, when attaching it might not be known until the
+	// broker tells us which one we got
+	session := p.Session
+	if !p.Attach {
+		sessionInfo := stubs.SessionInfo{}
+		if err = callContext(ctx, client, stubs.NewSession, stubs.Report{}, &sessionInfo); err != nil {
+			return shutdown(err)
+		}
+		session = sessionInfo.Session
+		fmt.Println("Session", session)
+	}
 
-	if globalListener == nil {
-		globalListener, _ = net.Listen("tcp", ":8091")
+	receiver := &StatusReceiver{
+		session:           session,
+		eventPasser:       make(chan Event, 10),
+		keyPressResponses: make(chan stubs.WorldResponse),
+		attachedStates:    make(chan stubs.AttachState),
+		checkpoints:       make(chan stubs.CheckpointResponse),
+		turnReports:       make(chan stubs.TurnChanges),
+		stopped:           make(chan bool),
+	}
+	listener, callbackAddr, err := startReceiver(p, receiver)
+	if err != nil {
+		return shutdown(err)
 	}
 
 	response := stubs.WorldResponse{}
 
 	// making a channel for the golengine to report down after all turns have been completed, then calling
 	// the server to process these turns, and accepting the server for rpc calls back
+	// if we're attaching to a run that's already going then the call returns in the same way once it's done
 	turnsFinished := make(chan *rpc.Call, 2)
 	fmt.Println("Heya")
 
-	client.Go(stubs.TakeTurns, stubs.WorldData{World: world, Width: p.ImageWidth, Height: p.ImageHeight, Turn: p.Turns, ClientIP: "127.0.0.1:8091", Threads: p.Threads}, &response, turnsFinished)
-	go acceptListener(&globalListener)
+	// what the live view is showing, the broker sends us the cells that change each turn to keep it up to date
+	depth := rule.Depth()
+	view := make([][]byte, p.ImageHeight)
+	for y := range view {
+		view[y] = make([]byte, p.ImageWidth)
+	}
+	if !p.Attach {
+		showFrame(c, view, world, depth, turn)
+	}
+	// keeps track of the turn the live view's on, so that the events we send when we stop say where we got to
+	showChanges := func(changes stubs.TurnChanges) {
+		showTurn(c, view, changes, depth)
+		turn = changes.Turn
+	}
+
+	if p.Attach {
+		client.Go(stubs.Attach, stubs.AttachRequest{Session: session, ClientIP: callbackAddr, Width: p.ImageWidth, Height: p.ImageHeight, FPS: p.FPS}, &response, turnsFinished)
+	} else {
+		client.Go(stubs.TakeTurns, stubs.WorldData{Session: session, World: util.PackWorld(world, rule.Depth()), Width: p.ImageWidth, Height: p.ImageHeight, Turn: p.Turns, StartTurn: turn, ClientIP: callbackAddr, Threads: p.Threads, WorkerThreads: p.WorkerThreads, Rule: rule, Topology: topology, FPS: p.FPS}, &response, turnsFinished)
+	}
+
+	// function to call the broker while it's running, it can still be sending us turns until it gets to our call,
+	// so those are shown while we wait
+	callBroker := func(method string, args interface{}) error {
+		call := client.Go(method, args, &stubs.Report{}, make(chan *rpc.Call, 1))
+		for {
+			select {
+			case <-call.Done:
+				return call.Error
+			case changes := <-receiver.turnReports:
+				showChanges(changes)
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+	}
 
-	// flag variables to manage pausing and halting
+	keyPressed := func(key rune) error {
+		return callBroker(stubs.KeyPressed, stubs.KeyPress{Session: session, Key: key})
+	}
+	// the broker sends the live view the world with the edit made, so there's nothing else to do here
+	editCells := func(edit Edit) error {
+		return callBroker(stubs.EditCells, stubs.EditRequest{Session: session, Cells: edit.Cells, Levels: edit.Levels})
+	}
+
+	// function to wait for the broker to call back with the world after a keypress, unless we're cancelled first
+	awaitWorld := func() (stubs.WorldResponse, error) {
+		for {
+			select {
+			case keyResponse := <-receiver.keyPressResponses:
+				return keyResponse, nil
+			case changes := <-receiver.turnReports:
+				showChanges(changes)
+			case <-ctx.Done():
+				return stubs.WorldResponse{}, ctx.Err()
+			}
+		}
+	}
+
+	// function to write out the world the broker sends back after s or w, w always writes an RLE pattern
+	saveWorld := func(key rune) error {
+		keyResponse, err := awaitWorld()
+		if err != nil {
+			return err
+		}
+		command := ioOutput
+		if key == 'w' {
+			command = ioOutputRLE
+		}
+		fileName := fmt.Sprint(p.ImageWidth, "x", p.ImageHeight, "x", keyResponse.Turn)
+		writeOutput(keyResponse.World.Unpack(), c, command, fileName, keyResponse.Turn)
+		return nil
+	}
+	// function to wait for the checkpoint the broker sends back after c, and save it
+	awaitCheckpoint := func() error {
+		select {
+		case checkpointResponse := <-receiver.checkpoints:
+			writeCheckpoint(p, c, checkpointResponse.Checkpoint)
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	// function to catch up with the turn the broker's moved to after n or r, the live view has already been sent it
+	awaitTurn := func() error {
+		keyResponse, err := awaitWorld()
+		if err == nil {
+			turn = keyResponse.Turn
+		}
+		return err
+	}
+
+	// flag variables to manage pausing and halting, and anything that's gone wrong
 	paused := false
 	halt := false
 	complete := false
+	var runErr error
 
 	// main loop for dealing with events from outside of the controller
-	ticker := time.NewTicker(2 * time.Second)
 	for {
-		// if a keypress has led to a halt, or the golengine has finished processing, then end the loop
-		if halt || complete {
+		// if a keypress has led to a halt, the golengine has finished processing, or something's gone wrong,
+		// then end the loop
+		if halt || complete || runErr != nil {
 			break
 		}
 		// select on relevant channels, code inside handles dealing with each event
 		if !paused {
 			select {
 
+			// the caller has given up on the run
+			case <-ctx.Done():
+				runErr = ctx.Err()
 			// just passes a passed event on to events channel (needs to be done here as it needs access to c)
-			case <-ticker.C:
-				tickerResponse := stubs.LiveCellsCount{}
-				client.Call(stubs.Ticker, stubs.Report{}, &tickerResponse)
-				c.events <- AliveCellsCount{CompletedTurns: tickerResponse.Turn, CellsCount: tickerResponse.LiveCells}
+			case event := <-receiver.eventPasser:
+				c.events <- event
+			case changes := <-receiver.turnReports:
+				showChanges(changes)
+			case edit := <-c.edits:
+				runErr = editCells(edit)
+			// we've attached to a running simulation, so catch up with where it is
+			case state := <-receiver.attachedStates:
+				session = state.Session
+				fmt.Println("Attached to session", session)
+				turn = state.Turn
+				p.Turns = state.Turns
+				depth = state.World.Depth
+				showFrame(c, view, state.World.Unpack(), depth, turn)
+				c.events <- TurnComplete{CompletedTurns: turn}
+				// the last controller left it paused, so it stays that way until p is pressed
+				if state.Paused {
+					c.events <- StateChange{turn, Paused}
+					paused = true
+				}
 			// if the server is done processsing, then we need to stop and then generate a PGM
-			case <-turnsFinished:
-				complete = true
+			case call := <-turnsFinished:
+				complete, runErr = runFinished(call, response, turn)
 
 			// if a key is pressed then we need to handle this press
 			case keyPress := <-c.keyPresses:
 				// key press is first send along to the golengine to deal with things on that end
-				// this will block until things are finished on the server side
-				keyResponse := stubs.WorldResponse{}
-				client.Call(stubs.KeyPressed, stubs.KeyPress{Key: keyPress}, &keyResponse)
+				// the broker then calls back with the current state of the world if we need it
+				if runErr = keyPressed(keyPress); runErr != nil {
+					break
+				}
 				// then deal with any client side behaviour by setting flag variables, and printing to console if
 				// required
 				switch keyPress {
-				case 's':
-					fileName := fmt.Sprint(p.ImageWidth, "x", p.ImageHeight, "x", keyResponse.Turn)
-					writePgm(worldFromLiveCells(keyResponse.LiveCells, p), c, fileName)
+				case 's', 'w':
+					runErr = saveWorld(keyPress)
+				// r starts the run again from the beginning
+				case 'r':
+					runErr = awaitTurn()
+				// c checkpoints the run, the broker keeps a copy as well as sending one back to us
+				case 'c':
+					runErr = awaitCheckpoint()
+				// q detaches us from the broker, which carries on running, use -attach to pick it back up
 				case 'q':
 					halt = true
 				case 'k':
-					fileName := fmt.Sprint(p.ImageWidth, "x", p.ImageHeight, "x", keyResponse.Turn)
-					writePgm(worldFromLiveCells(keyResponse.LiveCells, p), c, fileName)
+					var keyResponse stubs.WorldResponse
+					if keyResponse, runErr = awaitWorld(); runErr == nil {
+						// the broker stopped at this turn, which the live view might not have got to yet
+						turn = keyResponse.Turn
+						fileName := fmt.Sprint(p.ImageWidth, "x", p.ImageHeight, "x", turn)
+						writePgm(keyResponse.World.Unpack(), c, fileName, turn)
+					}
 					halt = true
 				case 'p':
-					fmt.Println(keyResponse.Turn)
+					var keyResponse stubs.WorldResponse
+					if keyResponse, runErr = awaitWorld(); runErr == nil {
+						fmt.Println(keyResponse.Turn)
+						turn = keyResponse.Turn
+						c.events <- StateChange{turn, Paused}
+					}
 					paused = true
 				}
 
 			}
 
 		} else {
-			keyPress := <-c.keyPresses
-			switch keyPress {
-			case 'p':
-				client.Call(stubs.KeyPressed, stubs.KeyPress{Key: keyPress}, &response)
-				paused = false
-			case 'k':
-				pausedResponse := stubs.WorldResponse{}
-				client.Call(stubs.KeyPressed, stubs.KeyPress{Key: keyPress}, &pausedResponse)
-				fileName := fmt.Sprint(p.ImageWidth, "x", p.ImageHeight, "x", response.Turn)
-				writePgm(worldFromLiveCells(response.LiveCells, p), c, fileName)
-				halt = true
+			select {
+			case <-ctx.Done():
+				runErr = ctx.Err()
+			case event := <-receiver.eventPasser:
+				c.events <- event
+			// the last frame before pausing can still be on its way
+			case changes := <-receiver.turnReports:
+				showChanges(changes)
+			case edit := <-c.edits:
+				runErr = editCells(edit)
+			// stepping through the last turn finishes the run
+			case call := <-turnsFinished:
+				complete, runErr = runFinished(call, response, turn)
+			case keyPress := <-c.keyPresses:
+				switch keyPress {
+				case 'p':
+					if runErr = keyPressed(keyPress); runErr == nil {
+						c.events <- StateChange{turn, Executing}
+					}
+					paused = false
+				// n steps on a single turn, and r goes back to the start, either way we stay paused
+				case 'n', 'r':
+					if runErr = keyPressed(keyPress); runErr == nil {
+						runErr = awaitTurn()
+					}
+				case 's', 'w':
+					if runErr = keyPressed(keyPress); runErr == nil {
+						runErr = saveWorld(keyPress)
+					}
+				case 'c':
+					if runErr = keyPressed(keyPress); runErr == nil {
+						runErr = awaitCheckpoint()
+					}
+				case '+', '-':
+					runErr = keyPressed(keyPress)
+				// q detaches, leaving the run paused on the broker
+				case 'q':
+					runErr = keyPressed(keyPress)
+					halt = true
+				case 'k':
+					if runErr = keyPressed(keyPress); runErr != nil {
+						break
+					}
+					var pausedResponse stubs.WorldResponse
+					if pausedResponse, runErr = awaitWorld(); runErr == nil {
+						turn = pausedResponse.Turn
+						fileName := fmt.Sprint(p.ImageWidth, "x", p.ImageHeight, "x", turn)
+						writePgm(pausedResponse.World.Unpack(), c, fileName, turn)
+					}
+					halt = true
+				}
 			}
 		}
 
 	}
+	// nothing else sent to the receiver is going to be handled now
+	close(receiver.stopped)
+
+	// if we've been cancelled then the run is stopped on the broker too, but we don't wait long in case the broker
+	// is what's stuck
+	if ctx.Err() != nil {
+		call := client.Go(stubs.Cancel, stubs.SessionInfo{Session: session}, &stubs.Report{}, make(chan *rpc.Call, 1))
+		select {
+		case <-call.Done:
+		case <-time.After(cancelTimeout):
+		}
+	}
 
 	// after main loop has ended send an event for the final turn, and create a final PGM of the world if necessary
 	if complete {
-		c.events <- FinalTurnComplete{CompletedTurns: turn, Alive: response.LiveCells}
+		turn = response.Turn
+		c.events <- FinalTurnComplete{CompletedTurns: turn, Alive: response.World.AliveCells()}
 		fileName := fmt.Sprint(p.ImageWidth, "x", p.ImageHeight, "x", p.Turns)
-		writePgm(worldFromLiveCells(response.LiveCells, p), c, fileName)
+		writePgm(response.World.Unpack(), c, fileName, turn)
 	}
 
-	client.Close()
-	// Make sure that the Io has finished any output before exiting.
-
-	c.ioCommand <- ioCheckIdle
-	<-c.ioIdle
+	return shutdown(runErr)
+}
 
-	c.events <- StateChange{turn, Quitting}
+// function to work out why the broker's call with the final world returned, it's only complete if the run is over,
+// the broker can also hand the world back because it's detached us for not keeping up, and carried on without us
+// turn is the last one we were shown, as the world the broker hands back can be from a while before that
+func runFinished(call *rpc.Call, response stubs.WorldResponse, turn int) (bool, error) {
+	switch {
+	case call.Error != nil:
+		return false, call.Error
+	case response.Detached:
+		return false, errors.New(fmt.Sprint("detached by the broker at turn ", turn, ", the run is still going, use -attach to pick it back up"))
+	default:
+		return true, nil
+	}
+}
 
-	// Close the channel to stop the SDL goroutine gracefully. Removing may cause deadlock.
-	close(c.events)
+// function to make an RPC call that gives up if the context is cancelled before it returns
+func callContext(ctx context.Context, client *rpc.Client, method string, args interface{}, reply interface{}) error {
+	call := client.Go(method, args, reply, make(chan *rpc.Call, 1))
+	select {
+	case <-call.Done:
+		return call.Error
+	case <-ctx.Done():
+		return ctx.Err()
+	}
 }
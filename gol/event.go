@@ -16,9 +16,11 @@ type Event interface {
 
 // AliveCellsCount is an Event notifying the user about the number of currently alive cells.
 // This Event should be sent every 2s.
+// Workers is how many workers the simulation is split between, which drops if any of them die.
 type AliveCellsCount struct { // implements Event
 	CompletedTurns int
 	CellsCount     int
+	Workers        int
 }
 
 // ImageOutputComplete is an Event notifying the user about the completion of output.
@@ -44,6 +46,13 @@ type StateChange struct { // implements Event
 	NewState       State
 }
 
+// ErrorEvent is an Event notifying the user that something went wrong, such as an image that couldn't be read
+// or written. If the world couldn't be read in, Run sends this and then shuts down.
+type ErrorEvent struct { // implements Event
+	CompletedTurns int
+	Err            error
+}
+
 // CellFlipped is an Event notifying the GUI about a change of state of a single cell.
 // This even should be sent every time a cell changes state.
 // Make sure to send this event for all cells that are alive when the image is loaded in.
@@ -52,6 +61,14 @@ type CellFlipped struct { // implements Event
 	Cell           util.Cell
 }
 
+// CellShaded is an Event notifying the GUI that a cell has changed to the given grey level.
+// It's sent instead of CellFlipped under Generations rules, where dying cells fade through grey levels.
+type CellShaded struct { // implements Event
+	CompletedTurns int
+	Cell           util.Cell
+	Level          byte
+}
+
 // TurnComplete is an Event notifying the GUI about turn completion.
 // SDL will render a frame when this event is sent.
 // All CellFlipped events must be sent *before* TurnComplete.
@@ -106,6 +123,14 @@ func (event ImageOutputComplete) GetCompletedTurns() int {
 	return event.CompletedTurns
 }
 
+func (event ErrorEvent) String() string {
+	return fmt.Sprintf("Error: %v", event.Err)
+}
+
+func (event ErrorEvent) GetCompletedTurns() int {
+	return event.CompletedTurns
+}
+
 func (event CellFlipped) String() string {
 	return fmt.Sprintf("")
 }
@@ -114,6 +139,14 @@ func (event CellFlipped) GetCompletedTurns() int {
 	return event.CompletedTurns
 }
 
+func (event CellShaded) String() string {
+	return fmt.Sprintf("")
+}
+
+func (event CellShaded) GetCompletedTurns() int {
+	return event.CompletedTurns
+}
+
 func (event TurnComplete) String() string {
 	return fmt.Sprintf("")
 }
@@ -1,15 +1,107 @@
 package gol
 
+import (
+	"context"
+
+	"uk.ac.bris.cs/gameoflife/util"
+)
+
 // Params provides the details of how to run the Game of Life and which image to load.
 type Params struct {
 	Turns       int
 	Threads     int
 	ImageWidth  int
 	ImageHeight int
+	// WorkerThreads is the number of goroutines each worker splits its strip between, 0 lets the workers decide
+	WorkerThreads int
+	// Rule is the birth/survival rule in B/S notation, e.g. B36/S23, defaults to B3/S23
+	// Generations rules can add a number of states, e.g. B2/S/C3, or be given as S/B/C, e.g. /2/3
+	Rule string
+	// Topology is what happens at the edges of the world, one of torus, plane, reflective, klein or cylinder,
+	// defaults to torus
+	Topology string
+	// Attach joins a simulation that's already running on the broker instead of starting a new one
+	// Session picks which one, 0 means whichever is running as long as there's only one
+	Attach  bool
+	Session int
+	// BrokerAddr is the address of the broker, ListenAddr is where we listen for the broker calling back,
+	// and CallbackAddr is the address the broker should call back to, if that's different (e.g. behind NAT)
+	BrokerAddr   string
+	ListenAddr   string
+	CallbackAddr string
+	// Resume is a checkpoint file to carry on from instead of starting from an image, its size, rule, topology
+	// and number of turns are used instead of the ones given here, see ResumeParams
+	Resume string
+	// InputPath is the image to start from, defaults to images/WxH.pgm. It can also be an RLE or .cells pattern,
+	// which is placed with its top left corner at PatternOffset, or in the middle of the board if that's nil.
+	// Anything not set that the file gives, the size and for RLE the rule, is taken from the file.
+	InputPath     string
+	PatternOffset *util.Cell
+	// OutputDir is the directory images and checkpoints are written to, defaults to out
+	// OutputFormat is pgm, rle or cells, defaults to pgm
+	OutputDir    string
+	OutputFormat string
+	// FPS is the most frames a second the live view is sent, turns in between are skipped, 0 lets the broker decide
+	FPS int
+	// CellScale is how many pixels across each cell starts off in the SDL window, 0 fits the board to the screen
+	CellScale int
+}
+
+// ParamsFromInput returns p with any unset ImageWidth, ImageHeight or Rule taken from p.InputPath.
+func ParamsFromInput(p Params) (Params, error) {
+	if p.InputPath == "" || (p.ImageWidth > 0 && p.ImageHeight > 0 && p.Rule != "") {
+		return p, nil
+	}
+	width, height, rule, err := readInputInfo(p.InputPath)
+	if err != nil {
+		return p, err
+	}
+	if p.ImageWidth <= 0 {
+		p.ImageWidth = width
+	}
+	if p.ImageHeight <= 0 {
+		p.ImageHeight = height
+	}
+	if p.Rule == "" {
+		p.Rule = rule
+	}
+	return p, nil
+}
+
+// ResumeParams returns p with the size, rule, topology and number of turns taken from the checkpoint in
+// p.Resume, so that everything else can be set up to match it before calling Run.
+func ResumeParams(p Params) (Params, error) {
+	resumed, _, err := loadCheckpoint(p)
+	return resumed, err
+}
+
+// Edit changes cells in the running simulation, each cell in Cells is set to the grey level at the same index in
+// Levels, 255 for alive and 0 for dead.
+type Edit struct {
+	Cells  []util.Cell
+	Levels []byte
 }
 
 // Run starts the processing of Game of Life. It should initialise channels and goroutines.
-func Run(p Params, events chan<- Event, keyPresses <-chan rune) {
+// Anything sent on edits is made to the board between turns, edits can be nil if there aren't going to be any.
+func Run(p Params, events chan<- Event, keyPresses <-chan rune, edits <-chan Edit) {
+	RunContext(context.Background(), p, events, keyPresses, edits)
+}
+
+// RunContext is Run, but it stops the run on the broker and closes events if ctx is cancelled.
+// It returns why the run didn't get to the end, such as the broker being unreachable, or nil if it did, or it
+// was stopped with a keypress.
+func RunContext(ctx context.Context, p Params, events chan<- Event, keyPresses <-chan rune, edits <-chan Edit) error {
+	// the size has to be known before anything else starts, if it can't be read then the image or checkpoint
+	// won't be readable either, which is reported when we get there
+	// when resuming everything the checkpoint gives is taken from it, whatever else we've been told
+	if p.Resume != "" && !p.Attach {
+		if resumed, err := ResumeParams(p); err == nil {
+			p = resumed
+		}
+	} else if fromInput, err := ParamsFromInput(p); err == nil {
+		p = fromInput
+	}
 
 	//	TODO: Put the missing channels in here.
 
@@ -18,6 +110,7 @@ func Run(p Params, events chan<- Event, keyPresses <-chan rune) {
 	ioFilename := make(chan string)
 	ioOutput := make(chan uint8)
 	ioInput := make(chan uint8)
+	ioResult := make(chan error)
 
 	ioChannels := ioChannels{
 		command:  ioCommand,
@@ -25,6 +118,7 @@ func Run(p Params, events chan<- Event, keyPresses <-chan rune) {
 		filename: ioFilename,
 		output:   ioOutput,
 		input:    ioInput,
+		result:   ioResult,
 	}
 	go startIo(p, ioChannels)
 
@@ -35,7 +129,9 @@ func Run(p Params, events chan<- Event, keyPresses <-chan rune) {
 		ioFilename: ioFilename,
 		ioOutput:   ioOutput,
 		ioInput:    ioInput,
+		ioResult:   ioResult,
 		keyPresses: keyPresses,
+		edits:      edits,
 	}
-	distributor(p, distributorChannels)
+	return distributor(ctx, p, distributorChannels)
 }
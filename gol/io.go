@@ -1,10 +1,10 @@
 package gol
 
 import (
+	"bufio"
 	"fmt"
-	"io/ioutil"
 	"os"
-	"strconv"
+	"path/filepath"
 	"strings"
 
 	"uk.ac.bris.cs/gameoflife/util"
@@ -17,6 +17,8 @@ type ioChannels struct {
 	filename <-chan string
 	output   <-chan uint8
 	input    chan<- uint8
+	// result is sent the outcome of every input and output, for input it's sent before any of the bytes
+	result chan<- error
 }
 
 // ioState is the internal ioState of the io goroutine.
@@ -33,97 +35,230 @@ type ioCommand uint8
 //		ioOutput 	= 0
 //		ioInput 	= 1
 //		ioCheckIdle = 2
+//		ioOutputRLE = 3
 const (
 	ioOutput ioCommand = iota
 	ioInput
 	ioCheckIdle
+	// ioOutputRLE is ioOutput, but always writes an RLE pattern, whatever format Params asks for
+	ioOutputRLE
 )
 
-// writePgmImage receives an array of bytes and writes it to a pgm file.
-func (io *ioState) writePgmImage() {
-	_ = os.Mkdir("out", os.ModePerm)
+// default directories, used if they're not given in Params
+const defaultImageDir = "images"
+const defaultOutputDir = "out"
 
-	// Request a filename from the distributor.
-	filename := <-io.channels.filename
+// inputPath returns the file the world is read from, images/WxH.pgm unless Params gives one.
+func inputPath(p Params) string {
+	if p.InputPath != "" {
+		return p.InputPath
+	}
+	return filepath.Join(defaultImageDir, fmt.Sprint(p.ImageWidth, "x", p.ImageHeight, ".pgm"))
+}
 
-	file, ioError := os.Create("out/" + filename + ".pgm")
-	util.Check(ioError)
-	defer file.Close()
+// outputDir returns the directory output files are written to, out unless Params gives one.
+func outputDir(p Params) string {
+	if p.OutputDir != "" {
+		return p.OutputDir
+	}
+	return defaultOutputDir
+}
 
-	_, _ = file.WriteString("P5\n")
-	//_, _ = file.WriteString("# PGM file writer by pnmmodules (https://github.com/owainkenwayucl/pnmmodules).\n")
-	_, _ = file.WriteString(strconv.Itoa(io.params.ImageWidth))
-	_, _ = file.WriteString(" ")
-	_, _ = file.WriteString(strconv.Itoa(io.params.ImageHeight))
-	_, _ = file.WriteString("\n")
-	_, _ = file.WriteString(strconv.Itoa(255))
-	_, _ = file.WriteString("\n")
+// image formats, chosen by file extension, anything that isn't RLE or .cells is taken to be a pgm (or pbm)
+const (
+	pgmFormat   = "pgm"
+	rleFormat   = "rle"
+	cellsFormat = "cells"
+)
 
-	world := make([][]byte, io.params.ImageHeight)
-	for i := range world {
-		world[i] = make([]byte, io.params.ImageWidth)
+// imageFormat returns the format of an image or pattern file from its extension.
+func imageFormat(path string) string {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".rle":
+		return rleFormat
+	case ".cells":
+		return cellsFormat
+	default:
+		return pgmFormat
 	}
+}
 
-	for y := 0; y < io.params.ImageHeight; y++ {
-		for x := 0; x < io.params.ImageWidth; x++ {
-			val := <-io.channels.output
-			//if val != 0 {
-			//	fmt.Println(x, y)
-			//}
-			world[y][x] = val
+// readInputInfo reads the width and height of an image or pattern file, along with the rule if it's an RLE
+// pattern that gives one.
+func readInputInfo(path string) (int, int, string, error) {
+	if imageFormat(path) == pgmFormat {
+		file, err := os.Open(path)
+		if err != nil {
+			return 0, 0, "", err
 		}
+		defer file.Close()
+		header, err := util.ReadNetpbmHeader(bufio.NewReader(file))
+		return header.Width, header.Height, "", err
 	}
 
-	for y := 0; y < io.params.ImageHeight; y++ {
-		for x := 0; x < io.params.ImageWidth; x++ {
-			_, ioError = file.Write([]byte{world[y][x]})
-			util.Check(ioError)
-		}
+	pattern, rule, err := readPattern(path)
+	if err != nil || len(pattern) == 0 {
+		return 0, 0, rule, err
 	}
+	return len(pattern[0]), len(pattern), rule, nil
+}
 
-	ioError = file.Sync()
-	util.Check(ioError)
+// readPattern reads an RLE or .cells pattern, returning the rule if it's an RLE pattern that gives one.
+func readPattern(path string) ([][]byte, string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, "", err
+	}
+	defer file.Close()
+	if imageFormat(path) == rleFormat {
+		return util.ReadRLE(file)
+	}
+	pattern, err := util.ReadCells(file)
+	return pattern, "", err
+}
 
-	fmt.Println("File", filename, "output done!")
+// placePattern puts a pattern on an empty board of the given size, with its top left corner at offset,
+// or in the middle of the board if offset is nil. Anything that falls off the board is lost.
+func placePattern(pattern [][]byte, width, height int, offset *util.Cell) [][]byte {
+	board := make([][]byte, height)
+	for y := range board {
+		board[y] = make([]byte, width)
+	}
+	left, top := 0, 0
+	if offset != nil {
+		left, top = offset.X, offset.Y
+	} else if len(pattern) > 0 {
+		left = (width - len(pattern[0])) / 2
+		top = (height - len(pattern)) / 2
+	}
+	for y, row := range pattern {
+		for x, cell := range row {
+			if top+y >= 0 && top+y < height && left+x >= 0 && left+x < width {
+				board[top+y][left+x] = cell
+			}
+		}
+	}
+	return board
 }
 
-// readPgmImage opens a pgm file and sends its data as an array of bytes.
-func (io *ioState) readPgmImage() {
+// writeImage receives an array of bytes and writes it out in the given format, a pgm if it's not RLE or .cells.
+// The distributor is sent whether it worked.
+func (io *ioState) writeImage(format string) {
 	// Request a filename from the distributor.
 	filename := <-io.channels.filename
-	data, ioError := ioutil.ReadFile("images/" + filename + ".pgm")
-	util.Check(ioError)
 
-	fields := strings.Fields(string(data))
+	world := make([][]byte, io.params.ImageHeight)
+	for y := range world {
+		world[y] = make([]byte, io.params.ImageWidth)
+		for x := range world[y] {
+			world[y][x] = <-io.channels.output
+		}
+	}
 
-	if fields[0] != "P5" {
-		panic("Not a pgm file")
+	ioError := io.writeWorld(world, filename, format)
+	if ioError == nil {
+		fmt.Println("File", filename, "output done!")
 	}
+	io.channels.result <- ioError
+}
 
-	width, _ := strconv.Atoi(fields[1])
-	if width != io.params.ImageWidth {
-		panic("Incorrect width")
+// writeWorld writes a world to a file in the output directory.
+func (io *ioState) writeWorld(world [][]byte, filename, format string) error {
+	if ioError := os.MkdirAll(outputDir(io.params), os.ModePerm); ioError != nil {
+		return ioError
 	}
 
-	height, _ := strconv.Atoi(fields[2])
-	if height != io.params.ImageHeight {
-		panic("Incorrect height")
+	if format != rleFormat && format != cellsFormat {
+		format = pgmFormat
 	}
+	file, ioError := os.Create(filepath.Join(outputDir(io.params), filename+"."+format))
+	if ioError != nil {
+		return ioError
+	}
+	defer file.Close()
 
-	maxval, _ := strconv.Atoi(fields[3])
-	if maxval != 255 {
-		panic("Incorrect maxval/bit depth")
+	switch format {
+	case rleFormat:
+		ioError = util.WriteRLE(file, world, paramsRule(io.params))
+	case cellsFormat:
+		ioError = util.WriteCells(file, world, filename)
+	default:
+		ioError = util.WriteNetpbm(file, world, "P5")
 	}
+	if ioError != nil {
+		return ioError
+	}
+	return file.Sync()
+}
 
-	image := []byte(fields[4])
+// readImage reads a pgm file, or an RLE or .cells pattern, depending on the extension of the filename it's given.
+// The distributor is sent whether it worked, then if it did, the image as an array of bytes.
+func (io *ioState) readImage() {
+	// Request a filename from the distributor.
+	filename := <-io.channels.filename
+	var image [][]byte
+	var ioError error
+	if imageFormat(filename) == pgmFormat {
+		image, ioError = io.readPgmImage(filename)
+	} else {
+		image, ioError = io.readPatternImage(filename)
+	}
+	io.channels.result <- ioError
+	if ioError != nil {
+		return
+	}
 
-	for _, b := range image {
-		io.channels.input <- b
+	for _, row := range image {
+		for _, b := range row {
+			io.channels.input <- b
+		}
 	}
 
 	fmt.Println("File", filename, "input done!")
 }
 
+// readPatternImage opens an RLE or .cells pattern, and places it on a board of the size we've been given.
+func (io *ioState) readPatternImage(filename string) ([][]byte, error) {
+	pattern, _, ioError := readPattern(filename)
+	if ioError != nil {
+		return nil, fmt.Errorf("%v: %v", filename, ioError)
+	}
+	return placePattern(pattern, io.params.ImageWidth, io.params.ImageHeight, io.params.PatternOffset), nil
+}
+
+// readPgmImage opens a pgm or pbm file, and checks it's the size we've been given.
+// Life-like rules only have alive and dead cells, so for those any grey is rounded to whichever is closest.
+func (io *ioState) readPgmImage(filename string) ([][]byte, error) {
+	file, ioError := os.Open(filename)
+	if ioError != nil {
+		return nil, ioError
+	}
+	defer file.Close()
+
+	image, ioError := util.ReadNetpbm(file)
+	if ioError != nil {
+		return nil, fmt.Errorf("%v: %v", filename, ioError)
+	}
+
+	if len(image[0]) != io.params.ImageWidth || len(image) != io.params.ImageHeight {
+		return nil, fmt.Errorf("%v is %dx%d, expected %dx%d", filename, len(image[0]), len(image), io.params.ImageWidth, io.params.ImageHeight)
+	}
+
+	if paramsRule(io.params).States <= 2 {
+		util.Threshold(image)
+	}
+	return image, nil
+}
+
+// paramsRule returns the rule given in Params, or the normal game of life if there isn't a valid one.
+func paramsRule(p Params) util.Rule {
+	rule, err := util.ParseRule(p.Rule)
+	if err != nil {
+		return util.ConwayRule()
+	}
+	return rule
+}
+
 // startIo should be the entrypoint of the io goroutine.
 func startIo(p Params, c ioChannels) {
 	io := ioState{
@@ -137,9 +272,11 @@ func startIo(p Params, c ioChannels) {
 		case command := <-io.channels.command:
 			switch command {
 			case ioInput:
-				io.readPgmImage()
+				io.readImage()
 			case ioOutput:
-				io.writePgmImage()
+				io.writeImage(io.params.OutputFormat)
+			case ioOutputRLE:
+				io.writeImage(rleFormat)
 			case ioCheckIdle:
 				io.channels.idle <- true
 			}
@@ -0,0 +1,88 @@
+package gol
+
+import (
+	"reflect"
+	"testing"
+
+	"uk.ac.bris.cs/gameoflife/util"
+)
+
+// TestPlacePattern tests placing a pattern in the middle of the board, at an offset, and partly off the edges,
+// where anything that doesn't fit is lost rather than wrapped around to the other side.
+func TestPlacePattern(t *testing.T) {
+	glider := [][]byte{{0, 255, 0}, {0, 0, 255}, {255, 255, 255}}
+	tests := []struct {
+		name   string
+		offset *util.Cell
+		board  [][]byte
+	}{
+		{
+			name: "middle",
+			board: [][]byte{
+				{0, 0, 0, 0, 0},
+				{0, 0, 255, 0, 0},
+				{0, 0, 0, 255, 0},
+				{0, 255, 255, 255, 0},
+				{0, 0, 0, 0, 0},
+			},
+		},
+		{
+			name:   "offset",
+			offset: &util.Cell{X: 2, Y: 1},
+			board: [][]byte{
+				{0, 0, 0, 0, 0},
+				{0, 0, 0, 255, 0},
+				{0, 0, 0, 0, 255},
+				{0, 0, 255, 255, 255},
+				{0, 0, 0, 0, 0},
+			},
+		},
+		{
+			name:   "off the bottom right",
+			offset: &util.Cell{X: 3, Y: 3},
+			board: [][]byte{
+				{0, 0, 0, 0, 0},
+				{0, 0, 0, 0, 0},
+				{0, 0, 0, 0, 0},
+				{0, 0, 0, 0, 255},
+				{0, 0, 0, 0, 0},
+			},
+		},
+		{
+			name:   "off the top left",
+			offset: &util.Cell{X: -1, Y: -1},
+			board: [][]byte{
+				{0, 255, 0, 0, 0},
+				{255, 255, 0, 0, 0},
+				{0, 0, 0, 0, 0},
+				{0, 0, 0, 0, 0},
+				{0, 0, 0, 0, 0},
+			},
+		},
+		{
+			name:   "off the board entirely",
+			offset: &util.Cell{X: 5, Y: 0},
+			board: [][]byte{
+				{0, 0, 0, 0, 0},
+				{0, 0, 0, 0, 0},
+				{0, 0, 0, 0, 0},
+				{0, 0, 0, 0, 0},
+				{0, 0, 0, 0, 0},
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if board := placePattern(glider, 5, 5, test.offset); !reflect.DeepEqual(board, test.board) {
+				t.Errorf("expected %v, got %v", test.board, board)
+			}
+		})
+	}
+
+	// a pattern bigger than the board is cut down to the middle of it
+	big := [][]byte{{255, 0, 0, 255}, {0, 255, 255, 0}, {0, 255, 255, 0}, {255, 0, 0, 255}}
+	expected := [][]byte{{255, 255}, {255, 255}}
+	if board := placePattern(big, 2, 2, nil); !reflect.DeepEqual(board, expected) {
+		t.Errorf("expected %v, got %v", expected, board)
+	}
+}
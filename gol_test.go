@@ -3,8 +3,8 @@ package main
 import (
 	"fmt"
 	"io/ioutil"
-	"strconv"
-	"strings"
+	"os"
+	"path/filepath"
 	"testing"
 
 	"uk.ac.bris.cs/gameoflife/gol"
@@ -31,7 +31,7 @@ func TestGol(t *testing.T) {
 				testName := fmt.Sprintf("%dx%dx%d-%d", p.ImageWidth, p.ImageHeight, p.Turns, p.Threads)
 				t.Run(testName, func(t *testing.T) {
 					events := make(chan gol.Event)
-					go gol.Run(p, events, nil)
+					go gol.Run(p, events, nil, nil)
 					var cells []util.Cell
 					for event := range events {
 						switch e := event.(type) {
@@ -86,44 +86,65 @@ func assertEqualBoard(t *testing.T, given, expected []util.Cell, p gol.Params) b
 }
 
 func readAliveCells(path string, width, height int) []util.Cell {
-	data, ioError := ioutil.ReadFile(path)
+	file, ioError := os.Open(path)
 	util.Check(ioError)
+	defer file.Close()
 
-	fields := strings.Fields(string(data))
-
-	if fields[0] != "P5" {
-		panic("Not a pgm file")
-	}
+	image, ioError := util.ReadNetpbm(file)
+	util.Check(ioError)
 
-	imageWidth, _ := strconv.Atoi(fields[1])
-	if imageWidth != width {
+	if len(image[0]) != width {
 		panic("Incorrect width")
 	}
 
-	imageHeight, _ := strconv.Atoi(fields[2])
-	if imageHeight != height {
+	if len(image) != height {
 		panic("Incorrect height")
 	}
 
-	maxval, _ := strconv.Atoi(fields[3])
-	if maxval != 255 {
-		panic("Incorrect maxval/bit depth")
-	}
-
-	image := []byte(fields[4])
-
 	var cells []util.Cell
 	for y := 0; y < height; y++ {
 		for x := 0; x < width; x++ {
-			cell := image[0]
+			cell := image[y][x]
 			if cell != 0 {
 				cells = append(cells, util.Cell{
 					X: x,
 					Y: y,
 				})
 			}
-			image = image[1:]
 		}
 	}
 	return cells
 }
+
+// TestMoreWorkersThanRows tests a blinker on a 3x3 board split between more workers than it has rows.
+func TestMoreWorkersThanRows(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gol")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	pattern := filepath.Join(dir, "blinker.rle")
+	if err := ioutil.WriteFile(pattern, []byte("x = 1, y = 3\no$o$o!\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	vertical := []util.Cell{{X: 1, Y: 0}, {X: 1, Y: 1}, {X: 1, Y: 2}}
+	horizontal := []util.Cell{{X: 0, Y: 1}, {X: 1, Y: 1}, {X: 2, Y: 1}}
+	for turns, expected := range [][]util.Cell{vertical, horizontal, vertical} {
+		p := gol.Params{Turns: turns, Threads: 4, ImageWidth: 3, ImageHeight: 3, Topology: "plane", InputPath: pattern}
+		t.Run(fmt.Sprintf("3x3x%d-%d", p.Turns, p.Threads), func(t *testing.T) {
+			events := make(chan gol.Event)
+			go gol.Run(p, events, nil, nil)
+			var cells []util.Cell
+			for event := range events {
+				switch e := event.(type) {
+				case gol.FinalTurnComplete:
+					cells = e.Alive
+				case gol.ErrorEvent:
+					t.Error(e.Err)
+				}
+			}
+			assertEqualBoard(t, cells, expected, p)
+		})
+	}
+}
@@ -3,10 +3,13 @@ package main
 import (
 	"flag"
 	"fmt"
+	"os"
 	"runtime"
 
 	"uk.ac.bris.cs/gameoflife/gol"
 	"uk.ac.bris.cs/gameoflife/sdl"
+	"uk.ac.bris.cs/gameoflife/tui"
+	"uk.ac.bris.cs/gameoflife/util"
 )
 
 // main is the function called when starting Game of Life with 'go run .'
@@ -20,17 +23,23 @@ func main() {
 		8,
 		"Specify the number of worker threads to use. Defaults to 8.")
 
+	flag.IntVar(
+		&params.WorkerThreads,
+		"wt",
+		0,
+		"Specify the number of goroutines each worker splits its strip between. Defaults to the worker's own setting.")
+
 	flag.IntVar(
 		&params.ImageWidth,
 		"w",
 		512,
-		"Specify the width of the image. Defaults to 512.")
+		"Specify the width of the image. Defaults to 512, or the width of the -input image.")
 
 	flag.IntVar(
 		&params.ImageHeight,
 		"h",
 		512,
-		"Specify the height of the image. Defaults to 512.")
+		"Specify the height of the image. Defaults to 512, or the height of the -input image.")
 
 	flag.IntVar(
 		&params.Turns,
@@ -38,31 +47,188 @@ func main() {
 		10000000000,
 		"Specify the number of turns to process. Defaults to 10000000000.")
 
+	flag.BoolVar(
+		&params.Attach,
+		"attach",
+		false,
+		"Attach to a simulation already running on the broker instead of starting a new one. Pressing q detaches.")
+
+	flag.IntVar(
+		&params.Session,
+		"session",
+		0,
+		"Session to attach to with -attach. Defaults to the only session running.")
+
+	flag.StringVar(
+		&params.BrokerAddr,
+		"broker",
+		"127.0.0.1:8050",
+		"Address of the broker. Defaults to 127.0.0.1:8050.")
+
+	flag.StringVar(
+		&params.ListenAddr,
+		"listen",
+		":0",
+		"Address to listen on for the broker calling back. Defaults to any free port.")
+
+	flag.StringVar(
+		&params.CallbackAddr,
+		"callback",
+		"",
+		"Address the broker should call back to. Defaults to 127.0.0.1 and the port being listened on.")
+
+	flag.StringVar(
+		&params.Rule,
+		"rule",
+		"B3/S23",
+		"Specify the birth/survival rule in B/S notation, e.g. B36/S23 for HighLife, or a Generations rule, e.g. /2/3 for Brian's Brain. Defaults to B3/S23.")
+
+	flag.StringVar(
+		&params.Topology,
+		"topology",
+		"torus",
+		"Specify what happens at the edges of the world: torus, plane, reflective, klein or cylinder. Defaults to torus.")
+
+	flag.StringVar(
+		&params.Resume,
+		"resume",
+		"",
+		"Specify a checkpoint file to resume from. The size, rule, topology and number of turns are taken from it.")
+
+	flag.StringVar(
+		&params.InputPath,
+		"input",
+		"",
+		"Specify a pgm image, or an RLE or .cells pattern, to start from. Defaults to images/<width>x<height>.pgm.")
+
+	offset := flag.String(
+		"offset",
+		"",
+		"Specify where the top left of an RLE or .cells pattern goes on the board, as x,y. Defaults to the middle of the board.")
+
+	flag.StringVar(
+		&params.OutputDir,
+		"out",
+		"out",
+		"Specify the directory to write images and checkpoints to. Defaults to out.")
+
+	flag.StringVar(
+		&params.OutputFormat,
+		"outformat",
+		"pgm",
+		"Specify the format to write images in: pgm, rle or cells. Defaults to pgm.")
+
+	flag.IntVar(
+		&params.FPS,
+		"fps",
+		30,
+		"Specify the most frames a second the live view is sent, turns in between are skipped. Defaults to 30.")
+
+	flag.IntVar(
+		&params.CellScale,
+		"scale",
+		0,
+		"Specify how many pixels across each cell is in the SDL window. Defaults to as big as fits on the screen.")
+
 	noVis := flag.Bool(
 		"noVis",
 		false,
 		"Disables the SDL window, so there is no visualisation during the tests.")
 
+	useTui := flag.Bool(
+		"tui",
+		false,
+		"Shows the board in the terminal instead of an SDL window, for when there's no display. The arrow keys pan around it.")
+
 	flag.Parse()
 
+	// with an input image, any size that isn't given is read from the image, and so is the rule for RLE
+	if params.InputPath != "" {
+		given := make(map[string]bool)
+		flag.Visit(func(f *flag.Flag) { given[f.Name] = true })
+		if !given["w"] {
+			params.ImageWidth = 0
+		}
+		if !given["h"] {
+			params.ImageHeight = 0
+		}
+		if !given["rule"] {
+			params.Rule = ""
+		}
+		fromInput, err := gol.ParamsFromInput(params)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		params = fromInput
+		if params.Rule == "" {
+			params.Rule = "B3/S23"
+		}
+	}
+	if *offset != "" {
+		var cell util.Cell
+		if _, err := fmt.Sscanf(*offset, "%d,%d", &cell.X, &cell.Y); err != nil {
+			fmt.Println("offset must be of the form x,y, got", *offset)
+			os.Exit(1)
+		}
+		params.PatternOffset = &cell
+	}
+
+	if params.Resume != "" {
+		resumed, err := gol.ResumeParams(params)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		params = resumed
+	}
+	if _, err := util.ParseRule(params.Rule); err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	if _, err := util.ParseTopology(params.Topology); err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	if params.OutputFormat != "pgm" && params.OutputFormat != "rle" && params.OutputFormat != "cells" {
+		fmt.Println("output format must be pgm, rle or cells, got", params.OutputFormat)
+		os.Exit(1)
+	}
+
 	fmt.Println("Threads:", params.Threads)
 	fmt.Println("Width:", params.ImageWidth)
 	fmt.Println("Height:", params.ImageHeight)
+	fmt.Println("Rule:", params.Rule)
+	fmt.Println("Topology:", params.Topology)
 
 	keyPresses := make(chan rune, 10)
 	events := make(chan gol.Event, 1000)
+	edits := make(chan gol.Edit, 10)
 
-	go gol.Run(params, events, keyPresses)
-	if !(*noVis) {
-		sdl.Run(params, events, keyPresses)
+	go gol.Run(params, events, keyPresses, edits)
+	if *useTui {
+		tui.Run(params, events, keyPresses)
+	} else if !(*noVis) {
+		sdl.Run(params, events, keyPresses, edits)
 	} else {
+		// the events channel is closed without a FinalTurnComplete if the run doesn't get to the end
 		complete := false
+		failed := false
 		for !complete {
-			event := <-events
-			switch event.(type) {
+			event, ok := <-events
+			if !ok {
+				break
+			}
+			switch e := event.(type) {
+			case gol.ErrorEvent:
+				fmt.Println(e)
+				failed = true
 			case gol.FinalTurnComplete:
 				complete = true
 			}
 		}
+		if failed {
+			os.Exit(1)
+		}
 	}
 }
@@ -22,7 +22,7 @@ func BenchmarkGol(b *testing.B) {
 		b.Run(name, func(b *testing.B) {
 			for i := 0; i < b.N; i++ {		
 				events := make(chan gol.Event)
-				go gol.Run(p, events, nil)
+				go gol.Run(p, events, nil, nil)
 				for range events {
 
 				}
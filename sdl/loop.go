@@ -2,12 +2,41 @@ package sdl
 
 import (
 	"fmt"
+	"strings"
+	"time"
+
 	"github.com/veandco/go-sdl2/sdl"
 	"uk.ac.bris.cs/gameoflife/gol"
+	"uk.ac.bris.cs/gameoflife/util"
 )
 
-func Run(p gol.Params, events <-chan gol.Event, keyPresses chan<- rune) {
-	w := NewWindow(int32(p.ImageWidth), int32(p.ImageHeight))
+// how far the mouse has to move with the button down before it's a drag rather than a click
+const dragThreshold = 4
+
+// how often the turns per second in the status is worked out again
+const rateInterval = time.Second
+
+// Run shows the simulation in an SDL window, p.CellScale sets how big the cells start off
+// n steps a single turn while paused, + and - speed up and slow down the turns, r restarts from the initial world
+// and w saves the board as an RLE pattern, these are sent on keyPresses along with p, s, q, k and c
+// the mouse wheel zooms in and out, and dragging with the mouse pans around the board
+// clicking on a cell while paused toggles it, and ctrl+v pastes an RLE or .cells pattern from the clipboard with
+// its top left corner under the mouse, these are sent on edits
+// the turn, alive cells, turns per second, whether it's paused and how many workers it's running on are shown in the
+// corner of the window and in its title
+func Run(p gol.Params, events <-chan gol.Event, keyPresses chan<- rune, edits chan<- gol.Edit) {
+	w := NewScaledWindow(int32(p.ImageWidth), int32(p.ImageHeight), int32(p.CellScale))
+	paused := false
+	// where the mouse button went down, and how far it's been dragged since
+	var dragX, dragY int32
+	dragging := false
+	// what's shown in the status, workers is 0 until the first alive cells count comes in
+	turn, alive, workers := 0, 0, 0
+	state := gol.Executing
+	// turns per second is measured from the turn and time at the start of each interval
+	rate := 0.0
+	rateTurn := 0
+	var rateTime time.Time
 
 sdlLoop:
 	for {
@@ -24,6 +53,73 @@ sdlLoop:
 					keyPresses <- 'q'
 				case sdl.K_k:
 					keyPresses <- 'k'
+				case sdl.K_c:
+					keyPresses <- 'c'
+				case sdl.K_n:
+					keyPresses <- 'n'
+				case sdl.K_r:
+					keyPresses <- 'r'
+				case sdl.K_w:
+					keyPresses <- 'w'
+				// + is shift and = on most keyboards, so = counts too
+				case sdl.K_PLUS, sdl.K_EQUALS, sdl.K_KP_PLUS:
+					keyPresses <- '+'
+				case sdl.K_MINUS, sdl.K_KP_MINUS:
+					keyPresses <- '-'
+				case sdl.K_v:
+					if sdl.GetModState()&sdl.KMOD_CTRL != 0 {
+						x, y, _ := sdl.GetMouseState()
+						if edit, err := pasteEdit(w, x, y); err != nil {
+							fmt.Println("Can't paste:", err)
+						} else {
+							edits <- edit
+						}
+					}
+				}
+			case *sdl.MouseButtonEvent:
+				if e.Button != sdl.BUTTON_LEFT {
+					break
+				}
+				if e.Type == sdl.MOUSEBUTTONDOWN {
+					dragX, dragY = 0, 0
+					dragging = false
+				} else if !dragging && paused {
+					if x, y, ok := w.CellAt(e.X, e.Y); ok {
+						level := byte(255)
+						if w.Level(x, y) != 0 {
+							level = 0
+						}
+						edits <- gol.Edit{Cells: []util.Cell{{X: x, Y: y}}, Levels: []byte{level}}
+					}
+				}
+			case *sdl.MouseWheelEvent:
+				notches := e.Y
+				if e.Direction == sdl.MOUSEWHEEL_FLIPPED {
+					notches = -notches
+				}
+				x, y, _ := sdl.GetMouseState()
+				w.Zoom(notches, x, y)
+				w.Redraw()
+			case *sdl.MouseMotionEvent:
+				if e.State&sdl.ButtonLMask() == 0 {
+					break
+				}
+				// small movements while clicking don't count, once it's a drag the board catches up with the mouse
+				if !dragging {
+					dragX += e.XRel
+					dragY += e.YRel
+					if dragX*dragX+dragY*dragY < dragThreshold*dragThreshold {
+						break
+					}
+					dragging = true
+					w.Pan(dragX, dragY)
+				} else {
+					w.Pan(e.XRel, e.YRel)
+				}
+				w.Redraw()
+			case *sdl.WindowEvent:
+				if e.Event == sdl.WINDOWEVENT_SIZE_CHANGED {
+					w.Redraw()
 				}
 			}
 		}
@@ -36,8 +132,33 @@ sdlLoop:
 			switch e := event.(type) {
 			case gol.CellFlipped:
 				w.FlipPixel(e.Cell.X, e.Cell.Y)
+			case gol.CellShaded:
+				w.ShadePixel(e.Cell.X, e.Cell.Y, e.Level)
 			case gol.TurnComplete:
+				turn = e.CompletedTurns
+				// the first turn could be anywhere, e.g. when attaching, so it only starts the measuring off
+				if rateTime.IsZero() {
+					rateTurn, rateTime = turn, time.Now()
+				} else if elapsed := time.Since(rateTime); elapsed >= rateInterval {
+					rate = float64(turn-rateTurn) / elapsed.Seconds()
+					rateTurn, rateTime = turn, time.Now()
+				}
+				w.SetStatus(statusText(turn, alive, workers, rate, state))
 				w.RenderFrame()
+			case gol.AliveCellsCount:
+				alive, workers = e.CellsCount, e.Workers
+				w.SetStatus(statusText(turn, alive, workers, rate, state))
+				w.Redraw()
+				fmt.Printf("Completed Turns %-8v%v\n", event.GetCompletedTurns(), event)
+			case gol.StateChange:
+				paused = e.NewState == gol.Paused
+				state = e.NewState
+				// time spent paused doesn't count towards the turns per second
+				rate = 0
+				rateTurn, rateTime = e.CompletedTurns, time.Now()
+				w.SetStatus(statusText(e.CompletedTurns, alive, workers, rate, state))
+				w.Redraw()
+				fmt.Printf("Completed Turns %-8v%v\n", event.GetCompletedTurns(), event)
 			case gol.FinalTurnComplete:
 				w.Destroy()
 				break sdlLoop
@@ -52,3 +173,43 @@ sdlLoop:
 	}
 
 }
+
+// function to put together the status shown over the board and in the title
+func statusText(turn, alive, workers int, rate float64, state gol.State) string {
+	if workers == 0 {
+		return fmt.Sprintf("Turn %v  %.0f turns/s  %v", turn, rate, state)
+	}
+	return fmt.Sprintf("Turn %v  Alive %v  %.0f turns/s  %v  %v workers", turn, alive, rate, state, workers)
+}
+
+// function to turn the pattern on the clipboard into an edit, with its top left corner at the cell under (x, y)
+// the whole of the pattern's bounding box is pasted, so dead cells in it clear whatever was there
+func pasteEdit(w *Window, x, y int32) (gol.Edit, error) {
+	left, top, ok := w.CellAt(x, y)
+	if !ok {
+		return gol.Edit{}, fmt.Errorf("mouse isn't over the board")
+	}
+	text, err := sdl.GetClipboardText()
+	if err != nil {
+		return gol.Edit{}, err
+	}
+	pattern, _, err := util.ReadRLE(strings.NewReader(text))
+	if err != nil {
+		var cellsErr error
+		if pattern, cellsErr = util.ReadCells(strings.NewReader(text)); cellsErr != nil {
+			return gol.Edit{}, err
+		}
+	}
+
+	edit := gol.Edit{}
+	for patternY, row := range pattern {
+		for patternX, level := range row {
+			cellX, cellY := left+patternX, top+patternY
+			if cellX < int(w.Width) && cellY < int(w.Height) {
+				edit.Cells = append(edit.Cells, util.Cell{X: cellX, Y: cellY})
+				edit.Levels = append(edit.Levels, level)
+			}
+		}
+	}
+	return edit, nil
+}
@@ -2,44 +2,102 @@ package sdl
 
 import (
 	"fmt"
+	"math"
+	"strings"
 
 	"github.com/veandco/go-sdl2/sdl"
 	"uk.ac.bris.cs/gameoflife/util"
 )
 
+// the pixel buffer is always one pixel per cell, zoom is how many screen pixels across each cell is drawn, and
+// offsetX, offsetY is the cell at the top left of the window, which can be part of the way into a cell
+// status is drawn over the top left corner of the board
 type Window struct {
 	Width, Height int32
 	window        *sdl.Window
 	renderer      *sdl.Renderer
 	texture       *sdl.Texture
 	pixels        []byte
+	zoom          float64
+	offsetX       float64
+	offsetY       float64
+	status        string
 }
 
+// how much each notch of the mouse wheel zooms by, and how far in or out it can go, in screen pixels per cell
+const zoomStep = 1.25
+const minZoom = 0.05
+const maxZoom = 64
+
+// once cells are drawn this many pixels across, lines are drawn between them
+const gridZoom = 8
+
+// how many screen pixels across each pixel of the status font is, and the gap around the status text
+const statusScale = 2
+const statusMargin = 4
+
+// the window's title, the status is added on the end
+const title = "GOL GUI"
+
 func filterEvent(e sdl.Event, userdata interface{}) bool {
-	return e.GetType() == sdl.KEYDOWN || e.GetType() == sdl.QUIT
+	switch e.GetType() {
+	case sdl.KEYDOWN, sdl.QUIT, sdl.MOUSEWHEEL, sdl.MOUSEMOTION, sdl.MOUSEBUTTONDOWN, sdl.MOUSEBUTTONUP, sdl.WINDOWEVENT:
+		return true
+	}
+	return false
 }
 
+// NewWindow creates a window with one pixel for each cell
 func NewWindow(width, height int32) *Window {
+	return NewScaledWindow(width, height, 1)
+}
+
+// NewScaledWindow creates a window that draws each cell as scale by scale pixels, a scale of 0 picks the biggest
+// that fits on the screen. Boards too big for the screen get a window that fits, and can be zoomed out or panned.
+func NewScaledWindow(width, height, scale int32) *Window {
 	err := sdl.Init(sdl.INIT_EVERYTHING)
 	util.Check(err)
-	window, err := sdl.CreateWindow("GOL GUI", sdl.WINDOWPOS_CENTERED, sdl.WINDOWPOS_CENTERED, width, height, sdl.WINDOW_SHOWN)
+	bounds, boundsErr := sdl.GetDisplayUsableBounds(0)
+	if scale <= 0 {
+		scale = 1
+		if boundsErr == nil {
+			scale = bounds.W / width
+			if bounds.H/height < scale {
+				scale = bounds.H / height
+			}
+			if scale < 1 {
+				scale = 1
+			}
+		}
+	}
+	windowWidth, windowHeight := width*scale, height*scale
+	if boundsErr == nil {
+		if windowWidth > bounds.W {
+			windowWidth = bounds.W
+		}
+		if windowHeight > bounds.H {
+			windowHeight = bounds.H
+		}
+	}
+
+	window, err := sdl.CreateWindow(title, sdl.WINDOWPOS_CENTERED, sdl.WINDOWPOS_CENTERED, windowWidth, windowHeight, sdl.WINDOW_SHOWN|sdl.WINDOW_RESIZABLE)
 	util.Check(err)
 	renderer, err := sdl.CreateRenderer(window, -1, sdl.WINDOW_SHOWN)
 	util.Check(err)
-	sdl.SetHint(sdl.HINT_RENDER_SCALE_QUALITY, "linear")
-	err = renderer.SetLogicalSize(width, height)
-	util.Check(err)
+	// cells are scaled up as blocks rather than being blurred into each other
+	sdl.SetHint(sdl.HINT_RENDER_SCALE_QUALITY, "nearest")
 	texture, err := renderer.CreateTexture(sdl.PIXELFORMAT_ARGB8888, sdl.TEXTUREACCESS_STATIC, width, height)
 	util.Check(err)
 
 	sdl.SetEventFilterFunc(filterEvent, nil)
 	return &Window{
-		width,
-		height,
-		window,
-		renderer,
-		texture,
-		make([]byte, width*height*4),
+		Width:    width,
+		Height:   height,
+		window:   window,
+		renderer: renderer,
+		texture:  texture,
+		pixels:   make([]byte, width*height*4),
+		zoom:     float64(scale),
 	}
 }
 
@@ -56,13 +114,165 @@ func (w *Window) Destroy() {
 func (w *Window) RenderFrame() {
 	err := w.texture.Update(nil, w.pixels, int(w.Width*4))
 	util.Check(err)
+	w.Redraw()
+}
+
+// Redraw draws the last frame again, for when the view has moved but the cells haven't changed
+func (w *Window) Redraw() {
+	err := w.renderer.SetDrawColor(0, 0, 0, 0xFF)
+	util.Check(err)
 	err = w.renderer.Clear()
 	util.Check(err)
-	err = w.renderer.Copy(w.texture, nil, nil)
+	board := w.boardRect()
+	err = w.renderer.Copy(w.texture, nil, &board)
 	util.Check(err)
+	if w.zoom >= gridZoom {
+		w.drawGrid(board)
+	}
+	w.drawStatus()
 	w.renderer.Present()
 }
 
+// SetStatus sets the text shown over the board and in the window title, it's drawn on the next redraw
+func (w *Window) SetStatus(status string) {
+	if status == w.status {
+		return
+	}
+	w.status = status
+	w.window.SetTitle(title + " - " + status)
+}
+
+// function to draw the status in the top left of the window, on a dark background so it shows over live cells
+func (w *Window) drawStatus() {
+	if w.status == "" {
+		return
+	}
+	text := []rune(strings.ToUpper(w.status))
+	var pixels []sdl.Rect
+	for i, char := range text {
+		glyph, ok := font[char]
+		if !ok {
+			glyph = font['?']
+		}
+		for row, bits := range glyph {
+			for column := 0; column < glyphWidth-1; column++ {
+				if bits&(4>>uint(column)) != 0 {
+					pixels = append(pixels, sdl.Rect{
+						X: statusMargin + int32(i*glyphWidth+column)*statusScale,
+						Y: statusMargin + int32(row)*statusScale,
+						W: statusScale,
+						H: statusScale,
+					})
+				}
+			}
+		}
+	}
+
+	background := sdl.Rect{
+		W: 2*statusMargin + int32(len(text)*glyphWidth-1)*statusScale,
+		H: 2*statusMargin + glyphHeight*statusScale,
+	}
+	err := w.renderer.SetDrawBlendMode(sdl.BLENDMODE_BLEND)
+	util.Check(err)
+	err = w.renderer.SetDrawColor(0, 0, 0, 0xB0)
+	util.Check(err)
+	err = w.renderer.FillRect(&background)
+	util.Check(err)
+	err = w.renderer.SetDrawColor(0xFF, 0xD0, 0x40, 0xFF)
+	util.Check(err)
+	err = w.renderer.FillRects(pixels)
+	util.Check(err)
+}
+
+// function to work out where the whole board is in the window, most of it is off screen when zoomed in
+func (w *Window) boardRect() sdl.Rect {
+	return sdl.Rect{
+		X: int32(math.Round(-w.offsetX * w.zoom)),
+		Y: int32(math.Round(-w.offsetY * w.zoom)),
+		W: int32(math.Round(float64(w.Width) * w.zoom)),
+		H: int32(math.Round(float64(w.Height) * w.zoom)),
+	}
+}
+
+// function to draw lines between the cells that are in the window
+func (w *Window) drawGrid(board sdl.Rect) {
+	windowWidth, windowHeight, err := w.renderer.GetOutputSize()
+	util.Check(err)
+	err = w.renderer.SetDrawColor(0x40, 0x40, 0x40, 0xFF)
+	util.Check(err)
+
+	top, bottom := clamp(board.Y, 0, windowHeight), clamp(board.Y+board.H, 0, windowHeight)
+	left, right := clamp(board.X, 0, windowWidth), clamp(board.X+board.W, 0, windowWidth)
+	firstX := int32(math.Max(math.Ceil(w.offsetX), 0))
+	for x := firstX; x <= w.Width; x++ {
+		screenX := int32(math.Round((float64(x) - w.offsetX) * w.zoom))
+		if screenX > windowWidth {
+			break
+		}
+		err = w.renderer.DrawLine(screenX, top, screenX, bottom)
+		util.Check(err)
+	}
+	firstY := int32(math.Max(math.Ceil(w.offsetY), 0))
+	for y := firstY; y <= w.Height; y++ {
+		screenY := int32(math.Round((float64(y) - w.offsetY) * w.zoom))
+		if screenY > windowHeight {
+			break
+		}
+		err = w.renderer.DrawLine(left, screenY, right, screenY)
+		util.Check(err)
+	}
+}
+
+// Zoom zooms in by a step for each notch of the mouse wheel, or out if notches is negative. The cell under the
+// point (x, y) in the window stays where it is.
+func (w *Window) Zoom(notches, x, y int32) {
+	zoom := math.Max(minZoom, math.Min(maxZoom, w.zoom*math.Pow(zoomStep, float64(notches))))
+	w.offsetX += float64(x)/w.zoom - float64(x)/zoom
+	w.offsetY += float64(y)/w.zoom - float64(y)/zoom
+	w.zoom = zoom
+	w.clampView()
+}
+
+// CellAt returns the cell under the point (x, y) in the window, ok is false if it's off the board
+func (w *Window) CellAt(x, y int32) (cellX, cellY int, ok bool) {
+	cellX = int(math.Floor(w.offsetX + float64(x)/w.zoom))
+	cellY = int(math.Floor(w.offsetY + float64(y)/w.zoom))
+	ok = cellX >= 0 && cellY >= 0 && cellX < int(w.Width) && cellY < int(w.Height)
+	return
+}
+
+// Level returns the grey level a cell is being shown as, 255 for alive and 0 for dead
+func (w *Window) Level(x, y int) byte {
+	return w.pixels[4*(y*int(w.Width)+x)]
+}
+
+// Pan moves the board by the given number of window pixels, e.g. as the mouse is dragged
+func (w *Window) Pan(dx, dy int32) {
+	w.offsetX -= float64(dx) / w.zoom
+	w.offsetY -= float64(dy) / w.zoom
+	w.clampView()
+}
+
+// function to stop the board being panned out of the window altogether, some of it always stays in view
+func (w *Window) clampView() {
+	windowWidth, windowHeight, err := w.renderer.GetOutputSize()
+	util.Check(err)
+	viewWidth := float64(windowWidth) / w.zoom
+	viewHeight := float64(windowHeight) / w.zoom
+	w.offsetX = math.Max(-viewWidth/2, math.Min(float64(w.Width)-viewWidth/2, w.offsetX))
+	w.offsetY = math.Max(-viewHeight/2, math.Min(float64(w.Height)-viewHeight/2, w.offsetY))
+}
+
+func clamp(value, low, high int32) int32 {
+	if value < low {
+		return low
+	}
+	if value > high {
+		return high
+	}
+	return value
+}
+
 func (w *Window) PollEvent() sdl.Event {
 	return sdl.PollEvent()
 }
@@ -87,6 +297,19 @@ func (w *Window) FlipPixel(x, y int) {
 	w.pixels[4*(y*width+x)+3] = ^w.pixels[4*(y*width+x)+3]
 }
 
+// ShadePixel sets a pixel to the given grey level, for cells that are dying under a Generations rule
+func (w *Window) ShadePixel(x, y int, level byte) {
+	if x < 0 || y < 0 || x >= int(w.Width) || y >= int(w.Height) {
+		panic(fmt.Sprintf("CellShaded event at (%d, %d) is outside the bounds of the window.", x, y))
+	}
+
+	width := int(w.Width)
+	w.pixels[4*(y*width+x)+0] = level
+	w.pixels[4*(y*width+x)+1] = level
+	w.pixels[4*(y*width+x)+2] = level
+	w.pixels[4*(y*width+x)+3] = 0xFF
+}
+
 func (w *Window) CountPixels() int {
 	count := 0
 	for i := 0; i < int(w.Width) * int(w.Height) * 4; i += 4 {
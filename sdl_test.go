@@ -97,7 +97,7 @@ func TestSdl(t *testing.T) {
 	t.Run(testName, func(t *testing.T) {
 		turnNum := 0
 		events := make(chan gol.Event)
-		go gol.Run(p, events, nil)
+		go gol.Run(p, events, nil, nil)
 		time.Sleep(2 * time.Second)
 		final := false
 		for event := range events {
@@ -105,7 +105,8 @@ func TestSdl(t *testing.T) {
 			case gol.CellFlipped:
 				sdlEvents <- e
 			case gol.TurnComplete:
-				turnNum++
+				// the live view skips turns if it can't keep up, so check the turn it's showing
+				turnNum = e.CompletedTurns
 				sdlEvents <- e
 				aliveCount := <-sdlAlive
 				if alive[turnNum] != aliveCount {
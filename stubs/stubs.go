@@ -6,62 +6,167 @@ import (
 
 var KeyPressResponse = "StatusReceiver.KeyPressResponse"
 var LiveCellReport = "StatusReceiver.LiveCellReport"
+var AttachedReport = "StatusReceiver.Attached"
+var CheckpointReport = "StatusReceiver.CheckpointReport"
+var TurnReport = "StatusReceiver.TurnReport"
 
 var TakeTurns = "GolBroker.MainGol"
 var KeyPressed = "GolBroker.KeyPress"
+var Attach = "GolBroker.Attach"
+var NewSession = "GolBroker.NewSession"
+var Cancel = "GolBroker.Cancel"
+var EditCells = "GolBroker.Edit"
+var RegisterWorker = "GolBroker.RegisterWorker"
 
 var InitialiseWorker = "GolWorker.StartWorker"
 var TakeTurn = "GolWorker.TakeTurn"
 var WorkerKeyPress = "GolWorker.KeyPress"
+var ExchangeBoundary = "GolWorker.ReceiveBoundary"
+var Ping = "GolWorker.Ping"
+var GetStrip = "GolWorker.GetStrip"
+var WorkerEdit = "GolWorker.Edit"
 
+// Session is the ID given out by NewSession, every call for a simulation after that carries it
+type SessionInfo struct {
+	Session int
+}
+
+// worlds are sent packed with one bit per cell, or a byte per cell for Generations rules, see util.PackedWorld
+// Threads is the number of workers to use, WorkerThreads is how many goroutines each of them uses, 0 lets
+// the workers decide for themselves
+// Turn is the number of turns to run for, StartTurn is the number already completed when resuming a checkpoint
+// FPS is how many frames a second the controller's live view wants at most, 0 leaves it up to the broker
 type WorldData struct {
-	World    [][]byte
-	Height   int
-	Width    int
-	Turn     int
-	Threads  int
+	Session       int
+	World         util.PackedWorld
+	Height        int
+	Width         int
+	Turn          int
+	StartTurn     int
+	Threads       int
+	WorkerThreads int
+	Rule          util.Rule
+	Topology      util.Topology
+	ClientIP      string
+	FPS           int
+}
+
+// sent by a controller that wants to attach to an already running simulation
+// Session 0 attaches to whichever simulation is running, as long as there's only one
+type AttachRequest struct {
+	Session  int
 	ClientIP string
+	Width    int
+	Height   int
+	FPS      int
+}
+
+// state of the simulation at the point a controller attaches, Turns is the number of turns it's running for
+// Paused is set if it's been left paused, so the controller has to unpause it
+type AttachState struct {
+	Session int
+	World   util.PackedWorld
+	Turn    int
+	Turns   int
+	Paused  bool
+}
+
+// checkpoint of a simulation, sent back to the controller when it asks for one
+type CheckpointResponse struct {
+	Session    int
+	Checkpoint util.Checkpoint
 }
 
 type WorkerInfo struct {
 	WorkerIP string
 }
 
+// Above and Below are the addresses of the workers holding the neighbouring strips, empty at an edge of the world
+// that doesn't wrap
 type WorldDataBounded struct {
 	Data   WorldData
 	Top    int
 	Bottom int
+	Above  string
+	Below  string
 }
 
+// halo row sent directly between neighbouring workers, Top is true if it is the receiver's top halo
+// Y is the index of the row in the world, so the receiver can spot rows left over from an old strip layout
+// Row is packed at the depth of the rule being run
 type BoundaryUpdate struct {
-	Top    []byte
-	Bottom []byte
-	Turn   int
+	Row  []byte
+	Y    int
+	Top  bool
+	Turn int
 }
 
+// World is either the whole world, or a worker's strip of it starting at row Top
+// Detached is set when a run hands a controller back the world because it's been detached, rather than because
+// the run is over, the run carries on without it
 type WorldResponse struct {
-	LiveCells []util.Cell
-	Turn      int
-	Liveness  byte
+	Session  int
+	World    util.PackedWorld
+	Top      int
+	Turn     int
+	Detached bool
+}
+
+// sent back by a worker after each turn, just how many cells are alive, as the halos go straight to its neighbours
+// if the broker asked for changes, Changed holds the index (y*width + x) of every cell in the strip that's changed
+// since it last asked, and Levels the grey level each one changed to, which is only sent for worlds with grey
+// levels in them. If it asked for a resync then Strip is the whole strip instead
+type TurnResponse struct {
+	AliveCount int
+	Turn       int
+	Changed    []uint32
+	Levels     []byte
+	Strip      util.PackedWorld
 }
 
 type BigWorldResponse struct {
 	World [][]byte
 }
 
+// Changes is set if the broker wants a frame for a controller's live view, Resync if the frame has to be the
+// whole strip, rather than the cells that have changed since the last one
 type TurnRequest struct {
-	Turn int
+	Turn    int
+	Changes bool
+	Resync  bool
+}
+
+// a turn's worth of changes for the controller's live view, Changed and Levels are as in TurnResponse
+// if Resync is set then the controller's view is out of date, so World is sent to replace it instead
+type TurnChanges struct {
+	Session int
+	Turn    int
+	Changed []uint32
+	Levels  []byte
+	Resync  bool
+	World   util.PackedWorld
 }
 
+// Workers is how many workers the simulation is currently split between
 type LiveCellsCount struct {
+	Session   int
 	LiveCells int
 	Turn      int
+	Workers   int
 }
 
 type Report struct {
 	Message string
 }
 
+// cells to change in a running simulation, each cell in Cells is set to the grey level at the same index in Levels
+type EditRequest struct {
+	Session int
+	Cells   []util.Cell
+	Levels  []byte
+}
+
 type KeyPress struct {
-	Key rune
+	Session int
+	Key     rune
 }
@@ -0,0 +1,157 @@
+package tui
+
+import (
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+	"time"
+
+	"uk.ac.bris.cs/gameoflife/gol"
+	"uk.ac.bris.cs/gameoflife/util"
+)
+
+// how often the terminal size is checked, in case it's been resized
+const resizeInterval = time.Second
+
+// Run shows the simulation in the terminal instead of an SDL window, for when there's no display, e.g. over SSH.
+// p, s, q, k, c, n, r, w, + and - are passed on like they are from the SDL window, and the arrow keys pan around
+// boards that are too big for the terminal.
+func Run(p gol.Params, events <-chan gol.Event, keyPresses chan<- rune) {
+	restore, err := makeRaw()
+	if err != nil {
+		fmt.Println("Can't use the terminal:", err)
+		restore = func() {}
+	}
+
+	keys := make(chan rune, 10)
+	go readKeys(keys)
+	// ctrl-c detaches rather than leaving the terminal in a mess, the run carries on on the broker
+	interrupts := make(chan os.Signal, 1)
+	signal.Notify(interrupts, os.Interrupt)
+	defer signal.Stop(interrupts)
+	resize := time.NewTicker(resizeInterval)
+	defer resize.Stop()
+
+	board := make([][]byte, p.ImageHeight)
+	for y := range board {
+		board[y] = make([]byte, p.ImageWidth)
+	}
+	cols, rows := terminalSize()
+	left, top := 0, 0
+	turn, alive := 0, 0
+	status := ""
+	var final string
+
+tuiLoop:
+	for {
+		select {
+		case key, ok := <-keys:
+			if !ok {
+				keys = nil
+				break
+			}
+			// the view moves a quarter of the way across for each press
+			viewWidth, viewHeight := viewSize(cols, rows)
+			switch key {
+			case 'p', 's', 'q', 'k', 'c', 'n', 'r', 'w', '+', '-':
+				keyPresses <- key
+			case '=':
+				keyPresses <- '+'
+			case keyUp:
+				top -= max(viewHeight/4, 1)
+			case keyDown:
+				top += max(viewHeight/4, 1)
+			case keyLeft:
+				left -= max(viewWidth/4, 1)
+			case keyRight:
+				left += max(viewWidth/4, 1)
+			}
+			left, top = clampView(left, top, cols, rows, p)
+			render(board, left, top, cols, rows, turn, alive, status)
+		case <-interrupts:
+			keyPresses <- 'q'
+		case <-resize.C:
+			cols, rows = terminalSize()
+			left, top = clampView(left, top, cols, rows, p)
+		case event, ok := <-events:
+			if !ok {
+				break tuiLoop
+			}
+			switch e := event.(type) {
+			case gol.CellFlipped:
+				board[e.Cell.Y][e.Cell.X] = ^board[e.Cell.Y][e.Cell.X]
+			case gol.CellShaded:
+				board[e.Cell.Y][e.Cell.X] = e.Level
+			case gol.TurnComplete:
+				turn = e.CompletedTurns
+				render(board, left, top, cols, rows, turn, alive, status)
+			case gol.AliveCellsCount:
+				alive = e.CellsCount
+			case gol.FinalTurnComplete:
+				final = fmt.Sprintf("Completed Turns %-8v%v", e.CompletedTurns, e)
+				break tuiLoop
+			default:
+				// anything else goes in the status line, printing it would scroll the board off the screen
+				if len(event.String()) > 0 {
+					status = fmt.Sprintf("Completed Turns %-8v%v", event.GetCompletedTurns(), event)
+					render(board, left, top, cols, rows, turn, alive, status)
+				}
+			}
+		}
+	}
+
+	restore()
+	if status != "" {
+		fmt.Println(status)
+	}
+	if final != "" {
+		fmt.Println(final)
+	}
+}
+
+// function to work out how many cells fit in the terminal, leaving room for the border and the status lines
+func viewSize(cols, rows int) (int, int) {
+	return max(cols-2, 1), max((rows-4)*2, 2)
+}
+
+// function to keep the view on the board, boards smaller than the view are shown from the top left
+func clampView(left, top, cols, rows int, p gol.Params) (int, int) {
+	viewWidth, viewHeight := viewSize(cols, rows)
+	left = min(left, p.ImageWidth-viewWidth)
+	top = min(top, p.ImageHeight-viewHeight)
+	return max(left, 0), max(top, 0)
+}
+
+// function to draw the part of the board in view, with where it is and how the run's going underneath
+func render(board [][]byte, left, top, cols, rows, turn, alive int, status string) {
+	viewWidth, viewHeight := viewSize(cols, rows)
+	width := min(viewWidth, len(board[0])-left)
+	height := min(viewHeight, len(board)-top)
+
+	var screen strings.Builder
+	screen.WriteString(cursorHome)
+	for _, line := range util.HalfBlocksToStrings(board, left, top, width, height) {
+		screen.WriteString(strings.TrimSuffix(line, "\n") + clearLine + "\n")
+	}
+	fmt.Fprintf(&screen, "Turn %-8v Alive %-8v x %v-%v y %v-%v of %vx%v%v\n", turn, alive, left, left+width-1,
+		top, top+height-1, len(board[0]), len(board), clearLine)
+	// no newline after the last line, or the screen would scroll
+	fmt.Fprintf(&screen, "%.*v%v", cols, "arrows pan, p pause, n step, +/- speed, r restart, s save, w save RLE, c checkpoint, q detach, k kill  "+status, clearLine)
+	screen.WriteString(clearBelow)
+	fmt.Print(screen.String())
+}
+
+func min(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func max(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
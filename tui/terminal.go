@@ -0,0 +1,86 @@
+package tui
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// keys that aren't a single rune, arrow keys come in as escape sequences
+const (
+	keyUp rune = -1 - iota
+	keyDown
+	keyRight
+	keyLeft
+)
+
+// ANSI escape sequences for drawing over the whole terminal
+const (
+	enterAltScreen = "\x1b[?1049h\x1b[?25l"
+	leaveAltScreen = "\x1b[?25h\x1b[?1049l"
+	cursorHome     = "\x1b[H"
+	clearLine      = "\x1b[K"
+	clearBelow     = "\x1b[J"
+)
+
+// function to run stty on the terminal, returns what it printed
+func stty(args ...string) (string, error) {
+	cmd := exec.Command("stty", args...)
+	cmd.Stdin = os.Stdin
+	output, err := cmd.Output()
+	return strings.TrimSpace(string(output)), err
+}
+
+// function to put the terminal into cbreak mode, so keys come through as soon as they're pressed, without echoing
+// returns a function to put the terminal back how it was
+func makeRaw() (func(), error) {
+	state, err := stty("-g")
+	if err != nil {
+		return nil, err
+	}
+	if _, err = stty("cbreak", "-echo"); err != nil {
+		return nil, err
+	}
+	fmt.Print(enterAltScreen)
+	return func() {
+		fmt.Print(leaveAltScreen)
+		stty(state)
+	}, nil
+}
+
+// function to get the size of the terminal in characters, falls back on 80x24 if it can't be found
+func terminalSize() (int, int) {
+	cols, rows := 80, 24
+	size, err := stty("size")
+	if err == nil {
+		fmt.Sscan(size, &rows, &cols)
+	}
+	return cols, rows
+}
+
+// function to read keys from the terminal and send them down a channel, turning arrow keys into keyUp etc.
+func readKeys(keys chan<- rune) {
+	reader := bufio.NewReader(os.Stdin)
+	for {
+		key, _, err := reader.ReadRune()
+		if err != nil {
+			close(keys)
+			return
+		}
+		// arrow keys are ESC [ A to ESC [ D
+		if key == '\x1b' {
+			// the rest of the sequence arrives with the escape, so a lone escape doesn't wait for more
+			if reader.Buffered() < 2 {
+				keys <- key
+				continue
+			}
+			if next, _ := reader.Peek(2); next[0] == '[' && next[1] >= 'A' && next[1] <= 'D' {
+				reader.Discard(2)
+				key = keyUp - rune(next[1]-'A')
+			}
+		}
+		keys <- key
+	}
+}
@@ -0,0 +1,65 @@
+package util
+
+import (
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// Checkpoint is everything needed to carry on a simulation from where it was saved.
+// Turn is the number of turns completed so far, and Turns is the number the simulation is meant to run for.
+type Checkpoint struct {
+	World    PackedWorld
+	Turn     int
+	Turns    int
+	Rule     Rule
+	Topology Topology
+}
+
+// Save writes the checkpoint to the given file, creating its directory if needed.
+// It's written to a temporary file first, so a crash part way through doesn't lose the last checkpoint.
+func (c Checkpoint) Save(path string) error {
+	if err := os.MkdirAll(filepath.Dir(path), os.ModePerm); err != nil {
+		return err
+	}
+	file, err := os.Create(path + ".tmp")
+	if err != nil {
+		return err
+	}
+	err = gob.NewEncoder(file).Encode(c)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(path + ".tmp")
+		return err
+	}
+	return os.Rename(path+".tmp", path)
+}
+
+// LoadCheckpoint reads a checkpoint written by Save.
+// A checkpoint that's corrupt, or from a newer version with rules or topologies we don't know, is an error.
+func LoadCheckpoint(path string) (Checkpoint, error) {
+	var c Checkpoint
+	file, err := os.Open(path)
+	if err != nil {
+		return c, err
+	}
+	defer file.Close()
+	if err = gob.NewDecoder(file).Decode(&c); err != nil {
+		return c, err
+	}
+	if !c.Rule.Valid() {
+		return c, fmt.Errorf("checkpoint has a rule with %d states", c.Rule.States)
+	}
+	if !c.Topology.Valid() {
+		return c, fmt.Errorf("checkpoint has unknown topology %d", c.Topology)
+	}
+	world := c.World
+	if world.Width <= 0 || world.Height <= 0 || world.Depth != c.Rule.Depth() || len(world.Bits) != RowBytes(world.Width, world.Depth)*world.Height {
+		return c, errors.New("checkpoint's world is corrupt")
+	}
+	return c, nil
+}
@@ -0,0 +1,97 @@
+package util
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// TestCheckpointRoundTrip tests that a checkpoint saved into a directory that doesn't exist yet loads back the
+// same, for a Life-like world and one with grey levels.
+func TestCheckpointRoundTrip(t *testing.T) {
+	dir, err := ioutil.TempDir("", "checkpoint")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	tests := []Checkpoint{
+		{World: PackWorld(testWorld(13, 7, false), 1), Turn: 42, Turns: 100, Rule: ConwayRule(), Topology: Torus},
+		{World: PackWorld(testWorld(9, 3, true), 8), Turn: 0, Turns: 5, Rule: mustParseRule(t, "B2/S/C30"), Topology: KleinBottle},
+	}
+	for i, checkpoint := range tests {
+		path := filepath.Join(dir, "checkpoints", fmt.Sprint(i, ".checkpoint"))
+		if err := checkpoint.Save(path); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := os.Stat(path + ".tmp"); !os.IsNotExist(err) {
+			t.Errorf("%d: expected the temporary file to be gone, got %v", i, err)
+		}
+		loaded, err := LoadCheckpoint(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(loaded, checkpoint) {
+			t.Errorf("%d: expected %+v, got %+v", i, checkpoint, loaded)
+		}
+	}
+}
+
+// TestLoadCheckpointErrors tests that missing and broken checkpoints are reported rather than loaded.
+func TestLoadCheckpointErrors(t *testing.T) {
+	dir, err := ioutil.TempDir("", "checkpoint")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	if _, err := LoadCheckpoint(filepath.Join(dir, "missing.checkpoint")); err == nil {
+		t.Error("expected an error loading a checkpoint that doesn't exist")
+	}
+	broken := filepath.Join(dir, "broken.checkpoint")
+	if err := ioutil.WriteFile(broken, []byte("this isn't a checkpoint"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadCheckpoint(broken); err == nil {
+		t.Error("expected an error loading a broken checkpoint")
+	}
+}
+
+// TestLoadCheckpointInvalid tests that checkpoints which decode, but have rules, topologies or worlds that can't
+// have been saved by us, are reported rather than loaded.
+func TestLoadCheckpointInvalid(t *testing.T) {
+	dir, err := ioutil.TempDir("", "checkpoint")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	valid := Checkpoint{World: PackWorld(testWorld(9, 3, false), 1), Turn: 1, Turns: 2, Rule: ConwayRule(), Topology: Torus}
+	tests := map[string]func(c *Checkpoint){
+		"no states":          func(c *Checkpoint) { c.Rule.States = 0 },
+		"too many states":    func(c *Checkpoint) { c.Rule.States = 257 },
+		"unknown topology":   func(c *Checkpoint) { c.Topology = Topology(len(topologyNames)) },
+		"negative topology":  func(c *Checkpoint) { c.Topology = -1 },
+		"no rows":            func(c *Checkpoint) { c.World.Height = 0 },
+		"missing bits":       func(c *Checkpoint) { c.World.Bits = c.World.Bits[1:] },
+		"depth doesn't fit":  func(c *Checkpoint) { c.World.Depth = 8 },
+		"width doesn't fit":  func(c *Checkpoint) { c.World.Width = 17 },
+		"height doesn't fit": func(c *Checkpoint) { c.World.Height = 4 },
+	}
+	for name, corrupt := range tests {
+		t.Run(name, func(t *testing.T) {
+			checkpoint := valid
+			corrupt(&checkpoint)
+			path := filepath.Join(dir, name+".checkpoint")
+			if err := checkpoint.Save(path); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := LoadCheckpoint(path); err == nil {
+				t.Error("expected an error")
+			}
+		})
+	}
+}
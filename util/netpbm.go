@@ -0,0 +1,260 @@
+package util
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+)
+
+// netpbmLineLength is how long lines of the plain (ASCII) formats are allowed to get before they're wrapped.
+const netpbmLineLength = 70
+
+// NetpbmHeader is the header of a PBM or PGM image. MaxVal is 1 for PBM images, which don't give one.
+type NetpbmHeader struct {
+	Magic  string
+	Width  int
+	Height int
+	MaxVal int
+}
+
+// ReadNetpbmHeader reads the header of a P1, P2, P4 or P5 image, skipping any comments,
+// and leaves r at the start of the pixel data.
+func ReadNetpbmHeader(r *bufio.Reader) (NetpbmHeader, error) {
+	var header NetpbmHeader
+	magic := make([]byte, 2)
+	if _, err := io.ReadFull(r, magic); err != nil {
+		return header, err
+	}
+	header.Magic = string(magic)
+
+	values := make([]int, 3)
+	fields := 3
+	switch header.Magic {
+	case "P1", "P4":
+		fields = 2
+		values[2] = 1
+	case "P2", "P5":
+	default:
+		return header, errors.New("not a PBM or PGM image, magic number is " + strconv.Quote(header.Magic))
+	}
+	for i := 0; i < fields; i++ {
+		value, err := readNetpbmInt(r)
+		if err != nil {
+			return header, err
+		}
+		values[i] = value
+	}
+	header.Width, header.Height, header.MaxVal = values[0], values[1], values[2]
+	if header.Width <= 0 || header.Height <= 0 {
+		return header, fmt.Errorf("bad image size %dx%d", header.Width, header.Height)
+	}
+	if header.MaxVal <= 0 || header.MaxVal > 65535 {
+		return header, fmt.Errorf("bad maxval %d", header.MaxVal)
+	}
+
+	// binary pixel data starts after exactly one whitespace byte, so it can begin with whitespace itself
+	if header.Magic == "P4" || header.Magic == "P5" {
+		if _, err := r.ReadByte(); err != nil {
+			return header, err
+		}
+	}
+	return header, nil
+}
+
+// skipNetpbmSpace skips over whitespace and comments, which run from a # to the end of the line.
+func skipNetpbmSpace(r *bufio.Reader) error {
+	for {
+		b, err := r.ReadByte()
+		if err != nil {
+			return err
+		}
+		switch b {
+		case ' ', '\t', '\n', '\r', '\v', '\f':
+		case '#':
+			if _, err := r.ReadString('\n'); err != nil {
+				return err
+			}
+		default:
+			return r.UnreadByte()
+		}
+	}
+}
+
+// readNetpbmInt reads the next decimal number, skipping any whitespace and comments before it.
+func readNetpbmInt(r *bufio.Reader) (int, error) {
+	if err := skipNetpbmSpace(r); err != nil {
+		return 0, err
+	}
+	value, digits := 0, 0
+	for {
+		b, err := r.ReadByte()
+		if err == io.EOF && digits > 0 {
+			break
+		}
+		if err != nil {
+			return 0, err
+		}
+		if b < '0' || b > '9' {
+			r.UnreadByte()
+			break
+		}
+		value = value*10 + int(b-'0')
+		digits++
+		if value > 1<<30 {
+			return 0, errors.New("number in image is too big")
+		}
+	}
+	if digits == 0 {
+		return 0, errors.New("expected a number in image")
+	}
+	return value, nil
+}
+
+// scaleNetpbm scales a sample from 0 to maxVal into a grey level from 0 to 255.
+func scaleNetpbm(value, maxVal int) (byte, error) {
+	if value > maxVal {
+		return 0, fmt.Errorf("sample %d is bigger than maxval %d", value, maxVal)
+	}
+	return byte((value*255 + maxVal/2) / maxVal), nil
+}
+
+// ReadNetpbm reads a P1, P2, P4 or P5 image, with any maxval, into grey levels from 0 to 255.
+// Black pixels in PBM images are taken to be alive cells, so are read as 255.
+func ReadNetpbm(r io.Reader) ([][]byte, error) {
+	buffered := bufio.NewReader(r)
+	header, err := ReadNetpbmHeader(buffered)
+	if err != nil {
+		return nil, err
+	}
+
+	world := make([][]byte, header.Height)
+	for y := range world {
+		world[y] = make([]byte, header.Width)
+		switch header.Magic {
+		case "P1":
+			for x := range world[y] {
+				if err := skipNetpbmSpace(buffered); err != nil {
+					return nil, err
+				}
+				b, _ := buffered.ReadByte()
+				if b != '0' && b != '1' {
+					return nil, fmt.Errorf("unexpected %q in PBM image", b)
+				}
+				if b == '1' {
+					world[y][x] = 255
+				}
+			}
+		case "P2":
+			for x := range world[y] {
+				value, err := readNetpbmInt(buffered)
+				if err != nil {
+					return nil, err
+				}
+				if world[y][x], err = scaleNetpbm(value, header.MaxVal); err != nil {
+					return nil, err
+				}
+			}
+		case "P4":
+			row := make([]byte, (header.Width+7)/8)
+			if _, err := io.ReadFull(buffered, row); err != nil {
+				return nil, err
+			}
+			for x := range world[y] {
+				if row[x/8]&(0x80>>uint(x%8)) != 0 {
+					world[y][x] = 255
+				}
+			}
+		case "P5":
+			// samples are two bytes, most significant first, if maxval doesn't fit in one
+			sampleBytes := 1
+			if header.MaxVal > 255 {
+				sampleBytes = 2
+			}
+			row := make([]byte, header.Width*sampleBytes)
+			if _, err := io.ReadFull(buffered, row); err != nil {
+				return nil, err
+			}
+			for x := range world[y] {
+				value := int(row[x*sampleBytes])
+				if sampleBytes == 2 {
+					value = value<<8 | int(row[x*2+1])
+				}
+				if world[y][x], err = scaleNetpbm(value, header.MaxVal); err != nil {
+					return nil, err
+				}
+			}
+		}
+	}
+	return world, nil
+}
+
+// WriteNetpbm writes a world of grey levels as a P1, P2, P4 or P5 image with a maxval of 255.
+// Cells from 128 up are written as black in PBM images.
+func WriteNetpbm(w io.Writer, world [][]byte, magic string) error {
+	width := 0
+	if len(world) > 0 {
+		width = len(world[0])
+	}
+	buffered := bufio.NewWriter(w)
+	switch magic {
+	case "P1", "P4":
+		fmt.Fprintf(buffered, "%v\n%d %d\n", magic, width, len(world))
+	case "P2", "P5":
+		fmt.Fprintf(buffered, "%v\n%d %d\n255\n", magic, width, len(world))
+	default:
+		return errors.New("can't write images with magic number " + strconv.Quote(magic))
+	}
+
+	for _, row := range world {
+		switch magic {
+		case "P1", "P2":
+			// the plain formats are written one row per line, wrapped if the line gets too long
+			lineLength := 0
+			for x, cell := range row {
+				sample := strconv.Itoa(int(cell))
+				if magic == "P1" {
+					sample = "0"
+					if cell >= 128 {
+						sample = "1"
+					}
+				}
+				if x > 0 && lineLength+len(sample)+1 > netpbmLineLength {
+					buffered.WriteByte('\n')
+					lineLength = 0
+				} else if x > 0 {
+					buffered.WriteByte(' ')
+					lineLength++
+				}
+				buffered.WriteString(sample)
+				lineLength += len(sample)
+			}
+			buffered.WriteByte('\n')
+		case "P4":
+			packed := make([]byte, (width+7)/8)
+			for x, cell := range row {
+				if cell >= 128 {
+					packed[x/8] |= 0x80 >> uint(x%8)
+				}
+			}
+			buffered.Write(packed)
+		case "P5":
+			buffered.Write(row)
+		}
+	}
+	return buffered.Flush()
+}
+
+// Threshold rounds every cell in a world to alive or dead, whichever it's closest to.
+func Threshold(world [][]byte) {
+	for _, row := range world {
+		for x, cell := range row {
+			if cell >= 128 {
+				row[x] = 255
+			} else {
+				row[x] = 0
+			}
+		}
+	}
+}
@@ -0,0 +1,169 @@
+package util
+
+import (
+	"bufio"
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// TestReadNetpbm tests reading every format, with comments in the header, maxvals other than 255 and binary
+// pixel data that starts with bytes that look like whitespace.
+func TestReadNetpbm(t *testing.T) {
+	tests := []struct {
+		name  string
+		image string
+		world [][]byte
+	}{
+		{
+			name:  "P1 with comments",
+			image: "P1\n# a comment\n3 2 # another one\n0 1 0\n1 0 1\n",
+			world: [][]byte{{0, 255, 0}, {255, 0, 255}},
+		},
+		{
+			name:  "P1 without spaces",
+			image: "P1 3 2\n010\n101\n",
+			world: [][]byte{{0, 255, 0}, {255, 0, 255}},
+		},
+		{
+			name:  "P2",
+			image: "P2\n2 2\n255\n0 255\n128 7\n",
+			world: [][]byte{{0, 255}, {128, 7}},
+		},
+		{
+			name:  "P2 with a small maxval",
+			image: "P2 3 1 15\n0 15 5\n",
+			world: [][]byte{{0, 255, 85}},
+		},
+		{
+			name:  "P2 with a maxval above 255",
+			image: "P2\n#comment\n3 1\n1000\n0 500 1000\n",
+			world: [][]byte{{0, 128, 255}},
+		},
+		{
+			name:  "P4",
+			image: "P4\n10 2\n\xa0\x40\xff\xc0",
+			world: [][]byte{{255, 0, 255, 0, 0, 0, 0, 0, 0, 255}, {255, 255, 255, 255, 255, 255, 255, 255, 255, 255}},
+		},
+		{
+			name:  "P5 starting with whitespace",
+			image: "P5\n3 1\n255\n\x20\x0a\xff",
+			world: [][]byte{{32, 10, 255}},
+		},
+		{
+			name:  "P5 with a comment",
+			image: "P5 # comment\n2 1 255\n\x00\x80",
+			world: [][]byte{{0, 128}},
+		},
+		{
+			name:  "P5 with two byte samples",
+			image: "P5\n3 1\n65535\n\x00\x00\x80\x00\xff\xff",
+			world: [][]byte{{0, 128, 255}},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			world, err := ReadNetpbm(strings.NewReader(test.image))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(world, test.world) {
+				t.Errorf("expected %v, got %v", test.world, world)
+			}
+		})
+	}
+}
+
+// TestReadNetpbmErrors tests that broken and truncated images are rejected.
+func TestReadNetpbmErrors(t *testing.T) {
+	tests := map[string]string{
+		"empty":              "",
+		"bad magic number":   "P3\n1 1\n255\n0 0 0\n",
+		"truncated header":   "P2\n3 ",
+		"missing maxval":     "P5\n3 1\n",
+		"zero size":          "P2\n0 1\n255\n",
+		"zero maxval":        "P2\n1 1\n0\n0\n",
+		"maxval too big":     "P2\n1 1\n65536\n0\n",
+		"sample over maxval": "P2\n2 1\n15\n0 16\n",
+		"bad P1 pixel":       "P1\n2 1\n0 2\n",
+		"truncated P1":       "P1\n2 2\n0 1\n1\n",
+		"truncated P2":       "P2\n2 2\n255\n0 1 2\n",
+		"truncated P4":       "P4\n9 2\n\xff\xff\xff",
+		"truncated P5":       "P5\n2 2\n255\n\x00\x00\x00",
+		"truncated 16 bit":   "P5\n2 1\n1000\n\x00\x00\x03",
+	}
+	for name, image := range tests {
+		t.Run(name, func(t *testing.T) {
+			if world, err := ReadNetpbm(strings.NewReader(image)); err == nil {
+				t.Errorf("expected an error, got %v", world)
+			}
+		})
+	}
+}
+
+// TestReadNetpbmHeader tests that the header is read, and the reader left at the start of the pixel data.
+func TestReadNetpbmHeader(t *testing.T) {
+	r := bufio.NewReader(strings.NewReader("P5\n# comment\n16 8\n# another\n255\n\n rest"))
+	header, err := ReadNetpbmHeader(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := NetpbmHeader{Magic: "P5", Width: 16, Height: 8, MaxVal: 255}
+	if header != expected {
+		t.Errorf("expected %+v, got %+v", expected, header)
+	}
+	rest, _ := r.ReadString(0)
+	if rest != "\n rest" {
+		t.Errorf("expected the pixel data to be %q, got %q", "\n rest", rest)
+	}
+}
+
+// TestNetpbmRoundTrip tests that worlds written with WriteNetpbm read back the same, with PBM images rounding
+// every cell to alive or dead.
+func TestNetpbmRoundTrip(t *testing.T) {
+	world := make([][]byte, 5)
+	for y := range world {
+		// wide enough that the plain formats have to wrap their lines
+		world[y] = make([]byte, 45)
+		for x := range world[y] {
+			world[y][x] = byte(x*37 + y*11)
+		}
+	}
+	thresholded := make([][]byte, len(world))
+	for y := range world {
+		thresholded[y] = append([]byte{}, world[y]...)
+	}
+	Threshold(thresholded)
+
+	for _, magic := range []string{"P1", "P2", "P4", "P5"} {
+		t.Run(magic, func(t *testing.T) {
+			var buffer bytes.Buffer
+			if err := WriteNetpbm(&buffer, world, magic); err != nil {
+				t.Fatal(err)
+			}
+			if magic == "P1" || magic == "P2" {
+				for _, line := range strings.Split(buffer.String(), "\n") {
+					if len(line) > netpbmLineLength {
+						t.Errorf("line is %d long, expected at most %d", len(line), netpbmLineLength)
+					}
+				}
+			}
+			read, err := ReadNetpbm(&buffer)
+			if err != nil {
+				t.Fatal(err)
+			}
+			expected := world
+			if magic == "P1" || magic == "P4" {
+				expected = thresholded
+			}
+			if !reflect.DeepEqual(read, expected) {
+				t.Errorf("expected %v, got %v", expected, read)
+			}
+		})
+	}
+
+	if err := WriteNetpbm(&bytes.Buffer{}, world, "P6"); err == nil {
+		t.Error("expected an error writing a P6 image")
+	}
+}
@@ -0,0 +1,111 @@
+package util
+
+import "math/bits"
+
+// PackedWorld is a world stored with one bit per cell, used to keep RPC traffic down.
+// Each row starts on a fresh byte, so a strip of rows is a contiguous run of Bits.
+// The lowest bit of each byte is the leftmost of its eight cells.
+// Worlds with grey levels in them (see Rule.Depth) can't be packed, so they have a Depth of 8 and keep a
+// whole byte per cell.
+type PackedWorld struct {
+	Width  int
+	Height int
+	Depth  int
+	Bits   []byte
+}
+
+// RowBytes returns the number of bytes used to store a single row of the given width and depth.
+func RowBytes(width, depth int) int {
+	if depth == 8 {
+		return width
+	}
+	return (width + 7) / 8
+}
+
+// PackRow packs a row of cells into bits, or copies it as it is if the depth is 8.
+func PackRow(row []byte, depth int) []byte {
+	packed := make([]byte, RowBytes(len(row), depth))
+	if depth == 8 {
+		copy(packed, row)
+		return packed
+	}
+	for x, cell := range row {
+		if cell != 0 {
+			packed[x/8] |= 1 << uint(x%8)
+		}
+	}
+	return packed
+}
+
+// UnpackRow turns a packed row back into cells.
+func UnpackRow(packed []byte, width, depth int) []byte {
+	row := make([]byte, width)
+	if depth == 8 {
+		copy(row, packed)
+		return row
+	}
+	for x := range row {
+		if packed[x/8]&(1<<uint(x%8)) != 0 {
+			row[x] = 255
+		}
+	}
+	return row
+}
+
+// PackWorld packs a whole world at the given depth.
+func PackWorld(world [][]byte, depth int) PackedWorld {
+	packed := PackedWorld{Height: len(world), Depth: depth}
+	if len(world) > 0 {
+		packed.Width = len(world[0])
+	}
+	stride := RowBytes(packed.Width, depth)
+	packed.Bits = make([]byte, 0, stride*packed.Height)
+	for _, row := range world {
+		packed.Bits = append(packed.Bits, PackRow(row, depth)...)
+	}
+	return packed
+}
+
+// Row returns the packed bits of row y.
+func (p PackedWorld) Row(y int) []byte {
+	stride := RowBytes(p.Width, p.Depth)
+	return p.Bits[y*stride : (y+1)*stride]
+}
+
+// Unpack turns a packed world back into cells.
+func (p PackedWorld) Unpack() [][]byte {
+	world := make([][]byte, p.Height)
+	for y := range world {
+		world[y] = UnpackRow(p.Row(y), p.Width, p.Depth)
+	}
+	return world
+}
+
+// AliveCount returns the number of alive cells in the world.
+func (p PackedWorld) AliveCount() int {
+	count := 0
+	for _, b := range p.Bits {
+		if p.Depth == 8 {
+			if b == 255 {
+				count++
+			}
+			continue
+		}
+		count += bits.OnesCount8(b)
+	}
+	return count
+}
+
+// AliveCells returns the coordinates of every alive cell in the world.
+func (p PackedWorld) AliveCells() []Cell {
+	cells := make([]Cell, 0)
+	for y := 0; y < p.Height; y++ {
+		row := UnpackRow(p.Row(y), p.Width, p.Depth)
+		for x, cell := range row {
+			if cell == 255 {
+				cells = append(cells, Cell{X: x, Y: y})
+			}
+		}
+	}
+	return cells
+}
@@ -0,0 +1,120 @@
+package util
+
+import (
+	"reflect"
+	"testing"
+)
+
+// testWorld makes a world with a mix of alive and dead cells, and grey levels if greys is set.
+func testWorld(width, height int, greys bool) [][]byte {
+	world := make([][]byte, height)
+	for y := range world {
+		world[y] = make([]byte, width)
+		for x := range world[y] {
+			switch {
+			case (x*7+y*3)%5 < 2:
+				world[y][x] = 255
+			case greys && (x+y)%3 == 0:
+				world[y][x] = byte(x*31 + y*17 + 1)
+			}
+		}
+	}
+	return world
+}
+
+// countAlive counts the alive cells in a world the slow way, to check the packed counts against.
+func countAlive(world [][]byte) ([]Cell, int) {
+	cells := make([]Cell, 0)
+	for y, row := range world {
+		for x, cell := range row {
+			if cell == 255 {
+				cells = append(cells, Cell{X: x, Y: y})
+			}
+		}
+	}
+	return cells, len(cells)
+}
+
+// TestPackRow tests that the leftmost cell of each group of eight goes in the lowest bit, and that the bits past
+// the end of a row are left as zero.
+func TestPackRow(t *testing.T) {
+	tests := []struct {
+		name   string
+		row    []byte
+		packed []byte
+	}{
+		{"empty", []byte{}, []byte{}},
+		{"leftmost", []byte{255, 0, 0, 0, 0, 0, 0, 0}, []byte{0x01}},
+		{"rightmost", []byte{0, 0, 0, 0, 0, 0, 0, 255}, []byte{0x80}},
+		{"second byte", []byte{0, 0, 0, 0, 0, 0, 0, 0, 255, 0, 255}, []byte{0x00, 0x05}},
+		{"padding left as zero", []byte{255, 255, 255}, []byte{0x07}},
+		{"all alive", []byte{255, 255, 255, 255, 255, 255, 255, 255, 255}, []byte{0xff, 0x01}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			packed := PackRow(test.row, 1)
+			if !reflect.DeepEqual(packed, test.packed) {
+				t.Errorf("expected %08b, got %08b", test.packed, packed)
+			}
+			if row := UnpackRow(packed, len(test.row), 1); !reflect.DeepEqual(row, test.row) {
+				t.Errorf("expected %v back, got %v", test.row, row)
+			}
+		})
+	}
+
+	// grey levels are kept as they are
+	row := []byte{0, 1, 128, 255}
+	if packed := PackRow(row, 8); !reflect.DeepEqual(packed, row) {
+		t.Errorf("expected %v, got %v", row, packed)
+	}
+}
+
+// TestPackWorld tests that worlds of widths that aren't multiples of 8 unpack to what they were, and that the
+// packed counts of alive cells match counting them in the unpacked world.
+func TestPackWorld(t *testing.T) {
+	for _, depth := range []int{1, 8} {
+		for _, width := range []int{1, 7, 8, 9, 16, 17, 63} {
+			world := testWorld(width, 5, depth == 8)
+			packed := PackWorld(world, depth)
+			if packed.Width != width || packed.Height != 5 || packed.Depth != depth {
+				t.Errorf("depth %d width %d: got a %dx%d world of depth %d", depth, width, packed.Width, packed.Height, packed.Depth)
+			}
+			if len(packed.Bits) != RowBytes(width, depth)*5 {
+				t.Errorf("depth %d width %d: expected %d bytes, got %d", depth, width, RowBytes(width, depth)*5, len(packed.Bits))
+			}
+			if unpacked := packed.Unpack(); !reflect.DeepEqual(unpacked, world) {
+				t.Errorf("depth %d width %d: expected %v, got %v", depth, width, world, unpacked)
+			}
+
+			expectedCells, expectedCount := countAlive(world)
+			if count := packed.AliveCount(); count != expectedCount {
+				t.Errorf("depth %d width %d: expected %d alive, got %d", depth, width, expectedCount, count)
+			}
+			if cells := packed.AliveCells(); !reflect.DeepEqual(cells, expectedCells) {
+				t.Errorf("depth %d width %d: expected %v, got %v", depth, width, expectedCells, cells)
+			}
+		}
+	}
+}
+
+// TestPackedRow tests that each row of a packed world can be picked out on its own, including the last.
+func TestPackedRow(t *testing.T) {
+	world := testWorld(10, 4, false)
+	packed := PackWorld(world, 1)
+	for y, row := range world {
+		if unpacked := UnpackRow(packed.Row(y), 10, 1); !reflect.DeepEqual(unpacked, row) {
+			t.Errorf("row %d: expected %v, got %v", y, row, unpacked)
+		}
+	}
+}
+
+// TestPackEmptyWorld tests that a world with no rows packs and unpacks without anything in it.
+func TestPackEmptyWorld(t *testing.T) {
+	packed := PackWorld([][]byte{}, 1)
+	if packed.Width != 0 || packed.Height != 0 || len(packed.Bits) != 0 {
+		t.Errorf("expected an empty world, got %+v", packed)
+	}
+	if len(packed.Unpack()) != 0 || packed.AliveCount() != 0 || len(packed.AliveCells()) != 0 {
+		t.Errorf("expected nothing alive in an empty world")
+	}
+}
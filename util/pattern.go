@@ -0,0 +1,275 @@
+package util
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// rleLineLength is how long lines of RLE are allowed to get before they're wrapped.
+const rleLineLength = 70
+
+// ReadRLE reads a pattern in Golly's run length encoded format, returning its cells as grey levels along with
+// the rule from its header, which is empty if there isn't one or it isn't a rule ParseRule understands.
+// Multi-state patterns use A for alive and B onwards for the dying states of that rule, states past X (24) are
+// written as two letters, p to y followed by A to X, so pA is 25 and yO is 255.
+func ReadRLE(r io.Reader) ([][]byte, string, error) {
+	scanner := bufio.NewScanner(r)
+	width, height := -1, -1
+	ruleString := ""
+	var body strings.Builder
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || line[0] == '#' {
+			continue
+		}
+		// the first line that isn't a comment is the header, e.g. x = 3, y = 3, rule = B3/S23
+		if width < 0 {
+			// the rule always comes last, and a bounded grid in it can have commas of its own
+			header := line
+			if i := strings.Index(header, "rule"); i >= 0 {
+				keyValue := strings.SplitN(header[i:], "=", 2)
+				if len(keyValue) != 2 {
+					return nil, "", errors.New("bad RLE header " + line)
+				}
+				ruleString = strings.TrimSpace(keyValue[1])
+				header = strings.TrimRight(strings.TrimSpace(header[:i]), ",")
+			}
+			for _, part := range strings.Split(header, ",") {
+				keyValue := strings.SplitN(part, "=", 2)
+				if len(keyValue) != 2 {
+					return nil, "", errors.New("bad RLE header " + line)
+				}
+				key := strings.TrimSpace(keyValue[0])
+				value := strings.TrimSpace(keyValue[1])
+				var err error
+				switch key {
+				case "x":
+					width, err = strconv.Atoi(value)
+				case "y":
+					height, err = strconv.Atoi(value)
+				}
+				if err != nil {
+					return nil, "", errors.New("bad RLE header " + line)
+				}
+			}
+			if width < 0 || height < 0 {
+				return nil, "", errors.New("RLE header is missing x or y: " + line)
+			}
+			continue
+		}
+		body.WriteString(line)
+		if strings.Contains(line, "!") {
+			break
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, "", err
+	}
+	if width < 0 {
+		return nil, "", errors.New("RLE has no header")
+	}
+
+	// Golly can add a bounded grid to the rule, e.g. B3/S23:T100,100, which we don't need
+	ruleString = strings.SplitN(ruleString, ":", 2)[0]
+	rule, err := ParseRule(ruleString)
+	if err != nil {
+		rule = ConwayRule()
+		ruleString = ""
+	}
+
+	pattern := make([][]byte, height)
+	for y := range pattern {
+		pattern[y] = make([]byte, width)
+	}
+	x, y, count := 0, 0, 0
+	// the first letter of a two letter state
+	var prefix rune
+	for _, tag := range body.String() {
+		if tag >= '0' && tag <= '9' && prefix == 0 {
+			count = count*10 + int(tag-'0')
+			continue
+		}
+		if tag >= 'p' && tag <= 'y' && prefix == 0 {
+			prefix = tag
+			continue
+		}
+		if prefix != 0 && (tag < 'A' || tag > 'X') {
+			return nil, "", fmt.Errorf("unexpected %q after %q in RLE", tag, prefix)
+		}
+		if count == 0 {
+			count = 1
+		}
+		switch {
+		case tag == '!':
+			return pattern, ruleString, nil
+		case tag == '$':
+			x = 0
+			y += count
+		case tag == ' ' || tag == '\t':
+		default:
+			level, ok := rleLevel(prefix, tag, rule)
+			if !ok {
+				return nil, "", fmt.Errorf("unexpected %q in RLE", tag)
+			}
+			if y >= height || x+count > width {
+				return nil, "", errors.New("RLE pattern is bigger than its header says")
+			}
+			for i := 0; i < count; i++ {
+				pattern[y][x] = level
+				x++
+			}
+		}
+		count = 0
+		prefix = 0
+	}
+	return pattern, ruleString, nil
+}
+
+// rleLevel returns the grey level for a cell tag in an RLE pattern, prefix is the p to y before the tag for
+// states past 24, or 0 if there isn't one.
+func rleLevel(prefix, tag rune, rule Rule) (byte, bool) {
+	switch {
+	case prefix == 0 && (tag == 'b' || tag == '.'):
+		return 0, true
+	case prefix == 0 && tag == 'o':
+		return 255, true
+	case tag >= 'A' && tag <= 'X':
+		state := int(tag-'A') + 1
+		if prefix != 0 {
+			state += int(prefix-'p'+1) * 24
+		}
+		return rule.Level(state), true
+	}
+	return 0, false
+}
+
+// rleTag returns the tag for a cell in an RLE pattern, using b and o unless the rule has dying states.
+// States past 24 get two letters, like Golly.
+func rleTag(level byte, rule Rule) string {
+	state := rule.State(level)
+	if rule.States <= 2 {
+		if state == 1 {
+			return "o"
+		}
+		return "b"
+	}
+	switch {
+	case state == 0:
+		return "."
+	case state <= 24:
+		return string(rune('A' + state - 1))
+	default:
+		return string([]byte{byte('p' + (state-25)/24), byte('A' + (state-25)%24)})
+	}
+}
+
+// WriteRLE writes a world out as a pattern in Golly's run length encoded format.
+func WriteRLE(w io.Writer, world [][]byte, rule Rule) error {
+	width := 0
+	if len(world) > 0 {
+		width = len(world[0])
+	}
+	buffered := bufio.NewWriter(w)
+	fmt.Fprintf(buffered, "x = %d, y = %d, rule = %v\n", width, len(world), rule)
+
+	// runs are wrapped onto a new line once the current one gets too long
+	lineLength := 0
+	writeRun := func(count int, tag string) {
+		run := tag
+		if count > 1 {
+			run = strconv.Itoa(count) + run
+		}
+		if lineLength+len(run) > rleLineLength {
+			buffered.WriteString("\n")
+			lineLength = 0
+		}
+		buffered.WriteString(run)
+		lineLength += len(run)
+	}
+
+	// dead cells at the end of a row are left off, and so are empty rows at the end of the pattern
+	rowEnds := 0
+	for _, row := range world {
+		end := len(row)
+		for end > 0 && rleTag(row[end-1], rule) == rleTag(0, rule) {
+			end--
+		}
+		if end > 0 {
+			if rowEnds > 0 {
+				writeRun(rowEnds, "$")
+			}
+			rowEnds = 0
+			for x := 0; x < end; {
+				tag := rleTag(row[x], rule)
+				count := 0
+				for x < end && rleTag(row[x], rule) == tag {
+					count++
+					x++
+				}
+				writeRun(count, tag)
+			}
+		}
+		rowEnds++
+	}
+	writeRun(1, "!")
+	buffered.WriteString("\n")
+	return buffered.Flush()
+}
+
+// ReadCells reads a pattern in the plaintext .cells format, where . is dead and O is alive.
+// Rows can be shorter than the widest one, and are padded with dead cells.
+func ReadCells(r io.Reader) ([][]byte, error) {
+	scanner := bufio.NewScanner(r)
+	pattern := make([][]byte, 0)
+	width := 0
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), " \t\r")
+		if strings.HasPrefix(line, "!") {
+			continue
+		}
+		row := make([]byte, len(line))
+		for x, cell := range line {
+			switch cell {
+			case '.':
+			case 'O', 'o', '*':
+				row[x] = 255
+			default:
+				return nil, fmt.Errorf("unexpected %q in .cells pattern", cell)
+			}
+		}
+		if len(row) > width {
+			width = len(row)
+		}
+		pattern = append(pattern, row)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	for y, row := range pattern {
+		if len(row) < width {
+			pattern[y] = append(row, make([]byte, width-len(row))...)
+		}
+	}
+	return pattern, nil
+}
+
+// WriteCells writes a world out as a pattern in the plaintext .cells format, anything not alive is written as dead.
+func WriteCells(w io.Writer, world [][]byte, name string) error {
+	buffered := bufio.NewWriter(w)
+	fmt.Fprintf(buffered, "!Name: %v\n", name)
+	for _, row := range world {
+		for _, cell := range row {
+			if cell == 255 {
+				buffered.WriteByte('O')
+			} else {
+				buffered.WriteByte('.')
+			}
+		}
+		buffered.WriteByte('\n')
+	}
+	return buffered.Flush()
+}
@@ -0,0 +1,263 @@
+package util
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func mustParseRule(t *testing.T, rulestring string) Rule {
+	t.Helper()
+	rule, err := ParseRule(rulestring)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return rule
+}
+
+// TestReadRLE tests reading RLE headers and bodies, including runs of $, bodies split over several lines and
+// Generations patterns with one and two letter states.
+func TestReadRLE(t *testing.T) {
+	c30 := mustParseRule(t, "B2/S/C30")
+	tests := []struct {
+		name    string
+		rle     string
+		pattern [][]byte
+		rule    string
+	}{
+		{
+			name:    "no rule",
+			rle:     "x = 3, y = 1\n3o!\n",
+			pattern: [][]byte{{255, 255, 255}},
+			rule:    "",
+		},
+		{
+			name:    "glider with rule and comments",
+			rle:     "#N Glider\n#C a comment\nx = 3, y = 3, rule = B3/S23\nbo$2bo$3o!\n",
+			pattern: [][]byte{{0, 255, 0}, {0, 0, 255}, {255, 255, 255}},
+			rule:    "B3/S23",
+		},
+		{
+			name:    "multi-line body",
+			rle:     "x = 4, y = 2\n2o\n2o$\n4o!\n",
+			pattern: [][]byte{{255, 255, 255, 255}, {255, 255, 255, 255}},
+		},
+		{
+			name:    "run of $",
+			rle:     "x = 2, y = 4\no2$bo!\n",
+			pattern: [][]byte{{255, 0}, {0, 0}, {0, 255}, {0, 0}},
+		},
+		{
+			name:    "anything after ! is ignored",
+			rle:     "x = 1, y = 1\no!\nthis isn't RLE\n",
+			pattern: [][]byte{{255}},
+		},
+		{
+			name:    "bounded grid is left off the rule",
+			rle:     "x = 1, y = 1, rule = B3/S23:T10,10\no!\n",
+			pattern: [][]byte{{255}},
+			rule:    "B3/S23",
+		},
+		{
+			name:    "unknown rule is ignored",
+			rle:     "x = 1, y = 1, rule = LifeHistory\no!\n",
+			pattern: [][]byte{{255}},
+			rule:    "",
+		},
+		{
+			name:    "generations",
+			rle:     "x = 3, y = 1, rule = B2/S/C3\nAB.!\n",
+			pattern: [][]byte{{255, mustParseRule(t, "B2/S/C3").Level(2), 0}},
+			rule:    "B2/S/C3",
+		},
+		{
+			name:    "two letter states",
+			rle:     "x = 4, y = 1, rule = B2/S/C30\nX2pAqD!\n",
+			pattern: [][]byte{{c30.Level(24), c30.Level(25), c30.Level(25), c30.Level(52)}},
+			rule:    "B2/S/C30",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			pattern, rule, err := ReadRLE(strings.NewReader(test.rle))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(pattern, test.pattern) {
+				t.Errorf("expected %v, got %v", test.pattern, pattern)
+			}
+			if rule != test.rule {
+				t.Errorf("expected rule %q, got %q", test.rule, rule)
+			}
+		})
+	}
+}
+
+// TestReadRLEErrors tests that broken RLE patterns are rejected, rather than read as something else.
+func TestReadRLEErrors(t *testing.T) {
+	tests := map[string]string{
+		"no header":           "3o!\n",
+		"empty":               "",
+		"only comments":       "#C nothing here\n",
+		"missing y":           "x = 3\n3o!\n",
+		"bad number":          "x = three, y = 1\n3o!\n",
+		"too wide":            "x = 2, y = 1\n3o!\n",
+		"too tall":            "x = 1, y = 1\no$o!\n",
+		"unknown tag":         "x = 1, y = 1\nz!\n",
+		"prefix without tag":  "x = 1, y = 1, rule = B2/S/C30\np!\n",
+		"prefix before digit": "x = 2, y = 1, rule = B2/S/C30\np2A!\n",
+	}
+	for name, rle := range tests {
+		t.Run(name, func(t *testing.T) {
+			if pattern, _, err := ReadRLE(strings.NewReader(rle)); err == nil {
+				t.Errorf("expected an error, got %v", pattern)
+			}
+		})
+	}
+}
+
+// TestRLERoundTrip tests that worlds written with WriteRLE read back the same, for Life-like rules and for
+// Generations rules with every state from one letter up to two.
+func TestRLERoundTrip(t *testing.T) {
+	tests := []struct {
+		name  string
+		rule  string
+		world [][]byte
+	}{
+		{
+			name:  "glider",
+			rule:  "B3/S23",
+			world: [][]byte{{0, 255, 0, 0}, {0, 0, 255, 0}, {255, 255, 255, 0}, {0, 0, 0, 0}},
+		},
+		{
+			name:  "empty",
+			rule:  "B3/S23",
+			world: [][]byte{{0, 0}, {0, 0}},
+		},
+		{
+			name: "long rows are wrapped",
+			rule: "B36/S23",
+			world: func() [][]byte {
+				row := make([]byte, 500)
+				for x := range row {
+					if x%3 != 0 {
+						row[x] = 255
+					}
+				}
+				return [][]byte{row, make([]byte, 500), row}
+			}(),
+		},
+	}
+	for _, states := range []int{3, 25, 30, 256} {
+		rule := Rule{States: states}
+		row := make([]byte, states)
+		for state := range row {
+			row[state] = rule.Level(state)
+		}
+		tests = append(tests, struct {
+			name  string
+			rule  string
+			world [][]byte
+		}{name: "every state of " + rule.String(), rule: "B2/S/C" + rule.String()[len("B/S/C"):], world: [][]byte{row}})
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			rule := mustParseRule(t, test.rule)
+			var buffer bytes.Buffer
+			if err := WriteRLE(&buffer, test.world, rule); err != nil {
+				t.Fatal(err)
+			}
+			for _, line := range strings.Split(buffer.String(), "\n") {
+				if len(line) > rleLineLength && !strings.HasPrefix(line, "x =") {
+					t.Errorf("line is %d long, expected at most %d", len(line), rleLineLength)
+				}
+			}
+			world, ruleString, err := ReadRLE(&buffer)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(world, test.world) {
+				t.Errorf("expected %v, got %v", test.world, world)
+			}
+			if ruleString != rule.String() {
+				t.Errorf("expected rule %v, got %v", rule, ruleString)
+			}
+		})
+	}
+}
+
+// TestWriteRLE tests that dead cells at the ends of rows, and empty rows at the end, are left off.
+func TestWriteRLE(t *testing.T) {
+	world := [][]byte{{255, 255, 0, 0}, {0, 0, 0, 0}, {0, 0, 0, 255}, {0, 0, 0, 0}}
+	var buffer bytes.Buffer
+	if err := WriteRLE(&buffer, world, ConwayRule()); err != nil {
+		t.Fatal(err)
+	}
+	expected := "x = 4, y = 4, rule = B3/S23\n2o2$3bo!\n"
+	if buffer.String() != expected {
+		t.Errorf("expected %q, got %q", expected, buffer.String())
+	}
+}
+
+// TestReadCells tests reading .cells patterns, including short rows being padded out to the widest one.
+func TestReadCells(t *testing.T) {
+	tests := []struct {
+		name    string
+		cells   string
+		pattern [][]byte
+	}{
+		{
+			name:    "glider",
+			cells:   "!Name: Glider\n.O.\n..O\nOOO\n",
+			pattern: [][]byte{{0, 255, 0}, {0, 0, 255}, {255, 255, 255}},
+		},
+		{
+			name:    "short and empty rows are padded",
+			cells:   "!Name: Padded\n.O\n\nOOO\nO\n",
+			pattern: [][]byte{{0, 255, 0}, {0, 0, 0}, {255, 255, 255}, {255, 0, 0}},
+		},
+		{
+			name:    "trailing spaces and other alive characters",
+			cells:   "*o  \r\n.O\n",
+			pattern: [][]byte{{255, 255}, {0, 255}},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			pattern, err := ReadCells(strings.NewReader(test.cells))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(pattern, test.pattern) {
+				t.Errorf("expected %v, got %v", test.pattern, pattern)
+			}
+		})
+	}
+
+	if pattern, err := ReadCells(strings.NewReader(".O\n.X\n")); err == nil {
+		t.Errorf("expected an error for an unknown character, got %v", pattern)
+	}
+}
+
+// TestCellsRoundTrip tests that worlds written with WriteCells read back the same, with anything that isn't
+// alive written as dead.
+func TestCellsRoundTrip(t *testing.T) {
+	world := [][]byte{{0, 255, 0}, {128, 0, 255}, {255, 255, 255}}
+	var buffer bytes.Buffer
+	if err := WriteCells(&buffer, world, "test"); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(buffer.String(), "!Name: test\n") {
+		t.Errorf("expected a name line, got %q", buffer.String())
+	}
+	read, err := ReadCells(&buffer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := [][]byte{{0, 255, 0}, {0, 0, 255}, {255, 255, 255}}
+	if !reflect.DeepEqual(read, expected) {
+		t.Errorf("expected %v, got %v", expected, read)
+	}
+}
@@ -0,0 +1,153 @@
+package util
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+)
+
+// Rule is a Life-like or Generations rule. A dead cell with n live neighbours is born if Birth[n] is set,
+// and a live cell with n live neighbours survives if Survive[n] is set.
+// States counts dead and alive as well as any dying states, so it's 2 for Life-like rules. With more than 2,
+// a live cell that doesn't survive goes through each dying state in turn before it's dead, and dying cells
+// don't count as live neighbours. Dying states are stored as grey levels, fading from alive towards dead.
+type Rule struct {
+	Birth   [9]bool
+	Survive [9]bool
+	States  int
+}
+
+// ConwayRule is the standard Game of Life, B3/S23.
+func ConwayRule() Rule {
+	rule, _ := ParseRule("B3/S23")
+	return rule
+}
+
+// ParseRule parses a rulestring in B/S notation, such as B3/S23 or B36/S23.
+// The two halves can be given in either order. Generations rules can be given either with a third C part
+// giving the number of states, such as B2/S/C3, or in S/B/C notation, such as /2/3 for Brian's Brain.
+func ParseRule(rulestring string) (Rule, error) {
+	rule := Rule{States: 2}
+	parts := strings.Split(strings.ToUpper(strings.TrimSpace(rulestring)), "/")
+	formatErr := errors.New("rule must be of the form B<digits>/S<digits>[/C<states>] or <digits>/<digits>/<states>, got " + rulestring)
+	if len(parts) != 2 && len(parts) != 3 {
+		return rule, formatErr
+	}
+
+	// S/B/C notation has no letters, so give the parts the letters they'd have had
+	if len(parts) == 3 && !strings.ContainsAny(rulestring, "BbSsCc") {
+		parts = []string{"S" + parts[0], "B" + parts[1], "C" + parts[2]}
+	}
+
+	seen := make(map[byte]bool)
+	for _, part := range parts {
+		if len(part) == 0 || (part[0] != 'B' && part[0] != 'S' && part[0] != 'C') || seen[part[0]] {
+			return rule, formatErr
+		}
+		seen[part[0]] = true
+
+		if part[0] == 'C' {
+			states, err := strconv.Atoi(part[1:])
+			if err != nil || states < 2 || states > 256 {
+				return rule, errors.New("number of states must be between 2 and 256, got " + rulestring)
+			}
+			rule.States = states
+			continue
+		}
+
+		counts := &rule.Birth
+		if part[0] == 'S' {
+			counts = &rule.Survive
+		}
+		for _, digit := range part[1:] {
+			if digit < '0' || digit > '8' {
+				return rule, errors.New("neighbour counts must be between 0 and 8, got " + rulestring)
+			}
+			counts[digit-'0'] = true
+		}
+	}
+	if !seen['B'] || !seen['S'] {
+		return rule, formatErr
+	}
+	return rule, nil
+}
+
+// String returns the rule in B/S notation, with the number of states on the end for Generations rules.
+func (r Rule) String() string {
+	var b strings.Builder
+	b.WriteString("B")
+	for n, born := range r.Birth {
+		if born {
+			b.WriteByte(byte('0' + n))
+		}
+	}
+	b.WriteString("/S")
+	for n, survives := range r.Survive {
+		if survives {
+			b.WriteByte(byte('0' + n))
+		}
+	}
+	if r.States > 2 {
+		b.WriteString("/C" + strconv.Itoa(r.States))
+	}
+	return b.String()
+}
+
+// Valid returns true if the rule has a number of states ParseRule would give it, one decoded from a file or sent
+// over RPC might not.
+func (r Rule) Valid() bool {
+	return r.States >= 2 && r.States <= 256
+}
+
+// Depth returns the number of bits needed for each cell when packing a world under this rule.
+func (r Rule) Depth() int {
+	if r.States > 2 {
+		return 8
+	}
+	return 1
+}
+
+// Level returns the grey level used to store the given state, 0 is dead, 1 is alive and the rest are dying.
+func (r Rule) Level(state int) byte {
+	switch {
+	case state == 1:
+		return 255
+	case state <= 0 || state >= r.States:
+		return 0
+	default:
+		return byte(255 * (r.States - state) / (r.States - 1))
+	}
+}
+
+// State returns the state stored as the given grey level, levels that don't match a state exactly are
+// rounded to the nearest dying state.
+func (r Rule) State(level byte) int {
+	switch {
+	case level == 255:
+		return 1
+	case level == 0 || r.States <= 2:
+		return 0
+	}
+	state := r.States - (int(level)*(r.States-1)+127)/255
+	if state < 2 {
+		state = 2
+	} else if state > r.States-1 {
+		state = r.States - 1
+	}
+	return state
+}
+
+// Next returns the next grey level of a cell with the given grey level and number of live neighbours.
+func (r Rule) Next(status, neighbours byte) byte {
+	state := r.State(status)
+	switch {
+	case state == 1 && r.Survive[neighbours]:
+		return 255
+	case state == 0 && r.Birth[neighbours]:
+		return 255
+	case state == 0:
+		return 0
+	default:
+		return r.Level(state + 1)
+	}
+}
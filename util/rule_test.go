@@ -0,0 +1,139 @@
+package util
+
+import "testing"
+
+// TestParseRule tests parsing rules in every notation ParseRule accepts.
+func TestParseRule(t *testing.T) {
+	tests := []struct {
+		rulestring string
+		birth      []int
+		survive    []int
+		states     int
+		expected   string
+	}{
+		{"B3/S23", []int{3}, []int{2, 3}, 2, "B3/S23"},
+		{"S23/B3", []int{3}, []int{2, 3}, 2, "B3/S23"},
+		{"b36/s23", []int{3, 6}, []int{2, 3}, 2, "B36/S23"},
+		{" B3/S23 ", []int{3}, []int{2, 3}, 2, "B3/S23"},
+		{"B0/S8", []int{0}, []int{8}, 2, "B0/S8"},
+		{"B3/S", []int{3}, nil, 2, "B3/S"},
+		{"B2/S/C3", []int{2}, nil, 3, "B2/S/C3"},
+		{"/2/3", []int{2}, nil, 3, "B2/S/C3"},
+		{"345/2/4", []int{2}, []int{3, 4, 5}, 4, "B2/S345/C4"},
+		{"C256/S1/B2", []int{2}, []int{1}, 256, "B2/S1/C256"},
+		{"B3/S23/C2", []int{3}, []int{2, 3}, 2, "B3/S23"},
+	}
+	for _, test := range tests {
+		t.Run(test.rulestring, func(t *testing.T) {
+			rule, err := ParseRule(test.rulestring)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var birth, survive [9]bool
+			for _, n := range test.birth {
+				birth[n] = true
+			}
+			for _, n := range test.survive {
+				survive[n] = true
+			}
+			if rule.Birth != birth || rule.Survive != survive || rule.States != test.states {
+				t.Errorf("expected B%v/S%v with %d states, got %+v", test.birth, test.survive, test.states, rule)
+			}
+			if rule.String() != test.expected {
+				t.Errorf("expected %v, got %v", test.expected, rule.String())
+			}
+		})
+	}
+}
+
+// TestParseRuleErrors tests that rules ParseRule doesn't understand are rejected.
+func TestParseRuleErrors(t *testing.T) {
+	for _, rulestring := range []string{
+		"",
+		"B9/S23",
+		"B3/S29",
+		"B3",
+		"B3/23",
+		"B3/B3",
+		"B3/S23/S2",
+		"B3/S23/C1",
+		"B3/S23/C257",
+		"B3/S23/CX",
+		"B3/S23/C3/X",
+		"Life",
+		"23/3",
+		"/2/1",
+	} {
+		t.Run(rulestring, func(t *testing.T) {
+			if rule, err := ParseRule(rulestring); err == nil {
+				t.Errorf("expected an error, got %v", rule)
+			}
+		})
+	}
+}
+
+// TestLevels tests that every state has its own grey level, and maps back to itself.
+func TestLevels(t *testing.T) {
+	for _, states := range []int{2, 3, 4, 25, 256} {
+		rule := Rule{States: states}
+		seen := make(map[byte]bool)
+		for state := 0; state < states; state++ {
+			level := rule.Level(state)
+			if seen[level] {
+				t.Errorf("C%d: state %d has the same level as another state, %d", states, state, level)
+			}
+			seen[level] = true
+			if rule.State(level) != state {
+				t.Errorf("C%d: state %d is level %d, which is read back as state %d", states, state, level, rule.State(level))
+			}
+		}
+	}
+}
+
+// TestNext tests the transitions of a Life-like rule and a Generations rule.
+func TestNext(t *testing.T) {
+	conway := ConwayRule()
+	brain, err := ParseRule("/2/3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dying := brain.Level(2)
+	tests := []struct {
+		name       string
+		rule       Rule
+		status     byte
+		neighbours byte
+		expected   byte
+	}{
+		{"conway dead with 2", conway, 0, 2, 0},
+		{"conway dead with 3", conway, 0, 3, 255},
+		{"conway alive with 1", conway, 255, 1, 0},
+		{"conway alive with 2", conway, 255, 2, 255},
+		{"conway alive with 3", conway, 255, 3, 255},
+		{"conway alive with 4", conway, 255, 4, 0},
+		{"brain dead with 2", brain, 0, 2, 255},
+		{"brain dead with 3", brain, 0, 3, 0},
+		{"brain alive starts dying", brain, 255, 2, dying},
+		{"brain dying dies", brain, dying, 2, 0},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if next := test.rule.Next(test.status, test.neighbours); next != test.expected {
+				t.Errorf("expected %d, got %d", test.expected, next)
+			}
+		})
+	}
+
+	// cells go through every dying state in turn, whatever their neighbours
+	rule, err := ParseRule("B2/S/C5")
+	if err != nil {
+		t.Fatal(err)
+	}
+	level := byte(255)
+	for state := 2; state <= 5; state++ {
+		level = rule.Next(level, 8)
+		if level != rule.Level(state) {
+			t.Errorf("expected state %d (%d), got %d", state, rule.Level(state), level)
+		}
+	}
+}
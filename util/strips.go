@@ -0,0 +1,21 @@
+package util
+
+// SegmentHeights splits a number of rows as evenly as possible between a number of workers or threads, with any
+// spare rows going one each to the first few. Fewer than one is taken to mean one.
+func SegmentHeights(height, threads int) []int {
+	if threads < 1 {
+		threads = 1
+	}
+	segmentHeight := height / threads
+	spare := height - (segmentHeight * threads)
+	heights := make([]int, 0)
+	for i := 0; i < threads; i++ {
+		currentHeight := segmentHeight
+		if spare > 0 {
+			currentHeight++
+			spare--
+		}
+		heights = append(heights, currentHeight)
+	}
+	return heights
+}
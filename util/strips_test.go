@@ -0,0 +1,26 @@
+package util
+
+import (
+	"reflect"
+	"testing"
+)
+
+// TestSegmentHeights tests that rows are split as evenly as possible, including between fewer than one thread.
+func TestSegmentHeights(t *testing.T) {
+	tests := []struct {
+		height, threads int
+		expected        []int
+	}{
+		{16, 4, []int{4, 4, 4, 4}},
+		{10, 4, []int{3, 3, 2, 2}},
+		{3, 5, []int{1, 1, 1, 0, 0}},
+		{7, 1, []int{7}},
+		{7, 0, []int{7}},
+		{7, -2, []int{7}},
+	}
+	for _, test := range tests {
+		if heights := SegmentHeights(test.height, test.threads); !reflect.DeepEqual(heights, test.expected) {
+			t.Errorf("%d rows between %d: expected %v, got %v", test.height, test.threads, test.expected, heights)
+		}
+	}
+}
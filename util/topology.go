@@ -0,0 +1,79 @@
+package util
+
+import "errors"
+
+// Topology describes what happens to cells at the edges of the world.
+type Topology int
+
+const (
+	// Torus wraps around both horizontally and vertically.
+	Torus Topology = iota
+	// Plane is a finite world with dead cells beyond every edge.
+	Plane
+	// Reflective edges mirror the world back on itself.
+	Reflective
+	// KleinBottle wraps horizontally, and wraps vertically with a left-right flip.
+	KleinBottle
+	// Cylinder wraps horizontally, with dead cells above the top and below the bottom.
+	Cylinder
+)
+
+var topologyNames = []string{"torus", "plane", "reflective", "klein", "cylinder"}
+
+// ParseTopology returns the topology with the given name.
+func ParseTopology(name string) (Topology, error) {
+	for i, topologyName := range topologyNames {
+		if name == topologyName {
+			return Topology(i), nil
+		}
+	}
+	return Torus, errors.New("unknown topology " + name + ", expected one of torus, plane, reflective, klein or cylinder")
+}
+
+func (t Topology) String() string {
+	if !t.Valid() {
+		return "unknown"
+	}
+	return topologyNames[t]
+}
+
+// Valid returns true if t is one of the topologies above, one decoded from a file or sent over RPC might not be.
+func (t Topology) Valid() bool {
+	return t >= 0 && int(t) < len(topologyNames)
+}
+
+// WrapsVertically returns true if the top and bottom rows of the world are neighbours.
+func (t Topology) WrapsVertically() bool {
+	return t == Torus || t == KleinBottle
+}
+
+// Wrap maps a neighbour at (x, y), which may be just off the edge of a w by h world, back onto the world.
+// It returns false if the neighbour is off the edge and counts as dead.
+func (t Topology) Wrap(x, y, w, h int) (int, int, bool) {
+	switch t {
+	case Plane:
+		return x, y, x >= 0 && x < w && y >= 0 && y < h
+	case Reflective:
+		if x < 0 {
+			x = -x - 1
+		} else if x >= w {
+			x = 2*w - x - 1
+		}
+		if y < 0 {
+			y = -y - 1
+		} else if y >= h {
+			y = 2*h - y - 1
+		}
+		return x, y, true
+	case KleinBottle:
+		if y < 0 || y >= h {
+			y = (y + h) % h
+			x = w - 1 - x
+		}
+		return (x + w) % w, y, true
+	case Cylinder:
+		return (x + w) % w, y, y >= 0 && y < h
+	default:
+		return (x + w) % w, (y + h) % h, true
+	}
+}
@@ -0,0 +1,90 @@
+package util
+
+import "testing"
+
+// TestWrap tests where every topology maps neighbours just off the edges and corners of a 4x3 world.
+func TestWrap(t *testing.T) {
+	const w, h = 4, 3
+	tests := []struct {
+		topology Topology
+		x, y     int
+		wx, wy   int
+		ok       bool
+	}{
+		{Torus, 1, 1, 1, 1, true},
+		{Torus, -1, 1, 3, 1, true},
+		{Torus, 4, 1, 0, 1, true},
+		{Torus, 1, -1, 1, 2, true},
+		{Torus, 1, 3, 1, 0, true},
+		{Torus, -1, -1, 3, 2, true},
+		{Torus, 4, 3, 0, 0, true},
+
+		{Plane, 1, 1, 1, 1, true},
+		{Plane, 0, 0, 0, 0, true},
+		{Plane, 3, 2, 3, 2, true},
+		{Plane, -1, 1, 0, 0, false},
+		{Plane, 4, 1, 0, 0, false},
+		{Plane, 1, -1, 0, 0, false},
+		{Plane, 1, 3, 0, 0, false},
+		{Plane, -1, -1, 0, 0, false},
+		{Plane, 4, 3, 0, 0, false},
+
+		{Reflective, 1, 1, 1, 1, true},
+		{Reflective, -1, 1, 0, 1, true},
+		{Reflective, 4, 1, 3, 1, true},
+		{Reflective, 1, -1, 1, 0, true},
+		{Reflective, 1, 3, 1, 2, true},
+		{Reflective, -1, -1, 0, 0, true},
+		{Reflective, 4, 3, 3, 2, true},
+
+		{KleinBottle, 1, 1, 1, 1, true},
+		{KleinBottle, -1, 1, 3, 1, true},
+		{KleinBottle, 4, 1, 0, 1, true},
+		{KleinBottle, 1, -1, 2, 2, true},
+		{KleinBottle, 0, 3, 3, 0, true},
+		{KleinBottle, -1, -1, 0, 2, true},
+		{KleinBottle, 4, 3, 3, 0, true},
+		{KleinBottle, -1, 3, 0, 0, true},
+
+		{Cylinder, 1, 1, 1, 1, true},
+		{Cylinder, -1, 1, 3, 1, true},
+		{Cylinder, 4, 1, 0, 1, true},
+		{Cylinder, 1, -1, 0, 0, false},
+		{Cylinder, 1, 3, 0, 0, false},
+		{Cylinder, -1, -1, 0, 0, false},
+		{Cylinder, 4, 3, 0, 0, false},
+	}
+	for _, test := range tests {
+		x, y, ok := test.topology.Wrap(test.x, test.y, w, h)
+		if ok != test.ok {
+			t.Errorf("%v (%d, %d): expected ok to be %v, got %v", test.topology, test.x, test.y, test.ok, ok)
+		} else if ok && (x != test.wx || y != test.wy) {
+			t.Errorf("%v (%d, %d): expected (%d, %d), got (%d, %d)", test.topology, test.x, test.y, test.wx, test.wy, x, y)
+		}
+	}
+}
+
+// TestParseTopology tests that every topology's name parses back to it.
+func TestParseTopology(t *testing.T) {
+	for _, topology := range []Topology{Torus, Plane, Reflective, KleinBottle, Cylinder} {
+		parsed, err := ParseTopology(topology.String())
+		if err != nil || parsed != topology {
+			t.Errorf("expected %v, got %v, %v", topology, parsed, err)
+		}
+	}
+	if _, err := ParseTopology("sphere"); err == nil {
+		t.Error("expected an error for an unknown topology")
+	}
+}
+
+// TestTopologyString tests that topologies that aren't one of ours, e.g. from a corrupt checkpoint, don't panic.
+func TestTopologyString(t *testing.T) {
+	for _, topology := range []Topology{-1, Topology(len(topologyNames)), 100} {
+		if topology.Valid() {
+			t.Errorf("expected %d not to be valid", int(topology))
+		}
+		if topology.String() != "unknown" {
+			t.Errorf("expected %d to be unknown, got %v", int(topology), topology)
+		}
+	}
+}
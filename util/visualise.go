@@ -106,3 +106,37 @@ func squaresToStrings(given, expected [][]uint8, width, height int) []string {
 
 	return output
 }
+
+// HalfBlocksToStrings renders part of a world in the same box as squaresToStrings, but with two rows of cells to
+// each line using half blocks, so more of it fits in a terminal. The part shown is width by height cells from
+// left, top, and anything past the edge of the world is left blank.
+func HalfBlocksToStrings(world [][]uint8, left, top, width, height int) []string {
+	alive := func(x, y int) bool {
+		return y >= 0 && y < len(world) && x >= 0 && x < len(world[y]) && world[y][x] == 0xFF
+	}
+
+	var output []string
+	output = append(output, "┌"+strings.Repeat("─", width)+"┐\n")
+	for y := top; y < top+height; y += 2 {
+		var line strings.Builder
+		line.WriteString("│")
+		for x := left; x < left+width; x++ {
+			upper := alive(x, y)
+			lower := y+1 < top+height && alive(x, y+1)
+			switch {
+			case upper && lower:
+				line.WriteString("█")
+			case upper:
+				line.WriteString("▀")
+			case lower:
+				line.WriteString("▄")
+			default:
+				line.WriteString(" ")
+			}
+		}
+		line.WriteString("│\n")
+		output = append(output, line.String())
+	}
+	output = append(output, "└"+strings.Repeat("─", width)+"┘\n")
+	return output
+}
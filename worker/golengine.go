@@ -1,10 +1,13 @@
 package main
 
 import (
+	"errors"
 	"flag"
 	"fmt"
 	"net"
 	"net/rpc"
+	"runtime"
+	"sync"
 	"time"
 
 	"uk.ac.bris.cs/gameoflife/stubs"
@@ -13,71 +16,120 @@ import (
 
 // channels to handle communication from rpc called functions
 var keyPresses chan rune = make(chan rune)
-var turnChan chan stubs.BoundaryUpdate = make(chan stubs.BoundaryUpdate)
-var worldResponses chan []util.Cell = make(chan []util.Cell)
+var worldResponses chan *stubs.TurnResponse = make(chan *stubs.TurnResponse)
+var stripRequests chan chan *stubs.WorldResponse = make(chan chan *stubs.WorldResponse)
+var cellEdits chan stubs.EditRequest = make(chan stubs.EditRequest)
 var stopRunning chan bool = make(chan bool)
 var ticker chan bool = make(chan bool)
 var liveCellChan chan stubs.LiveCellsCount = make(chan stubs.LiveCellsCount)
 var keyPressResponses chan stubs.WorldResponse = make(chan stubs.WorldResponse)
 
-// struct to store relevant data about a given world
-func encodeCells(cells []util.Cell) []util.Cell {
-	newCells := make([]util.Cell, 0)
-	for _, cell := range cells {
-		newCells = append(newCells, util.Cell{X: cell.X + 1, Y: cell.Y + 1})
-	}
-	return newCells
+// the channels belonging to one run of this worker, StartWorker replaces them all at once for each new run
+// abort is closed to make the runner give up so that a new StartWorker can take over, and done is closed
+// once the runner has finished, or if it never started
+// turns takes the broker's turn requests, so one meant for an old run can't be picked up by the next
+// the halos are rows pushed to this worker by its neighbours, buffered so a neighbour never waits on us
+// there's room for one stale row left over from a run that was abandoned after a worker died
+type workerRun struct {
+	abort      chan bool
+	done       chan bool
+	turns      chan stubs.TurnRequest
+	topHalo    chan stubs.BoundaryUpdate
+	bottomHalo chan stubs.BoundaryUpdate
 }
 
+// the current run, which is nil until the first StartWorker. rpc handlers take a copy of it under runLock
+// rather than reading it while StartWorker might be replacing it
+var currentRun *workerRun
+var runLock sync.Mutex
+
+// only one StartWorker can be stopping the old run and starting a new one at a time
+var startLock sync.Mutex
+
+// number of goroutines each turn is split between, unless the controller asks for something else
+var defaultThreads int = runtime.NumCPU()
+
+// struct to store relevant data about a given world
 type WorldState struct {
-	World     [][]byte
-	LiveCells []util.Cell
+	World [][]byte
 }
 
 // main engine of Game of life, calculates the next state of a world in game of life, and returns it
-func calculateNextState(world [][]byte, width, height, top, bottom int) WorldState {
+// the rows from top to bottom are split between the given number of goroutines, then merged back together
+func calculateNextState(world [][]byte, width, height, top, bottom, threads int, rule util.Rule, topology util.Topology) WorldState {
 
 	// makes an empty world to store live cells
-	newWorld := make([][]byte, width)
-	for i := range world {
-		newWorld[i] = make([]byte, height)
+	newWorld := make([][]byte, height)
+	for i := range newWorld {
+		newWorld[i] = make([]byte, width)
 	}
 
-	newLiveCells := make([]util.Cell, 0)
+	// starts a goroutine for each segment of the strip, each one reports its rows down its own channel
+	results := make([]chan [][]byte, 0)
+	segmentStart := top
+	for _, segmentHeight := range util.SegmentHeights(bottom-top, threads) {
+		result := make(chan [][]byte, 1)
+		go calculateSegment(world, width, height, segmentStart, segmentStart+segmentHeight, rule, topology, result)
+		results = append(results, result)
+		segmentStart += segmentHeight
+	}
 
-	// goes through every cell
-	for y, row := range world {
-		if y >= top && y < bottom {
-			for x, status := range row {
-				// scores each cell
-				score := scoreCell(x, y, width, height, world)
-				// sets the next status of the world in accordance with the rules of the game of life
-				var newStatus byte = 0
-				if status == 255 {
-					if score == 2 || score == 3 {
-						newStatus = 255
-					}
-				} else {
-					if score == 3 {
-						newStatus = 255
-					}
-				}
+	// merges the segments back into the new world, in order
+	y := top
+	for _, result := range results {
+		for _, row := range <-result {
+			newWorld[y] = row
+			y++
+		}
+	}
 
-				if newStatus == 255 {
-					newLiveCells = append(newLiveCells, util.Cell{X: x, Y: y})
-				}
+	return WorldState{World: newWorld}
+}
 
-				// sets the value of the cell in the new world to this value
-				newWorld[y][x] = newStatus
+// function to find the cells in our strip that have changed since the live view's last frame, returns the index of
+// each one, and for worlds with grey levels in them the level it changed to
+// shown is our strip as it was in the last frame, starting at row top, and is brought up to date
+func findChanges(shown, world [][]byte, top, bottom, depth int) ([]uint32, []byte) {
+	changed := make([]uint32, 0)
+	var levels []byte
+	if depth == 8 {
+		levels = make([]byte, 0)
+	}
+	for y := top; y < bottom; y++ {
+		for x, cell := range world[y] {
+			if cell != shown[y-top][x] {
+				changed = append(changed, uint32(y*len(world[y])+x))
+				if depth == 8 {
+					levels = append(levels, cell)
+				}
+				shown[y-top][x] = cell
 			}
 		}
 	}
+	return changed, levels
+}
+
+// function to calculate the next state of the rows from top to bottom, and send them down the result channel
+func calculateSegment(world [][]byte, width, height, top, bottom int, rule util.Rule, topology util.Topology, result chan [][]byte) {
+	rows := make([][]byte, 0, bottom-top)
+
+	// goes through every cell
+	for y := top; y < bottom; y++ {
+		newRow := make([]byte, width)
+		for x, status := range world[y] {
+			// scores each cell
+			score := scoreCell(x, y, width, height, world, topology)
+			// sets the next status of the world in accordance with the rule we've been given
+			newRow[x] = rule.Next(status, score)
+		}
+		rows = append(rows, newRow)
+	}
 
-	return WorldState{World: newWorld, LiveCells: newLiveCells}
+	result <- rows
 }
 
 // function to score an individual cell by the number of live neighbours it has
-func scoreCell(x, y, w, h int, world [][]byte) byte {
+func scoreCell(x, y, w, h int, world [][]byte, topology util.Topology) byte {
 
 	var score byte = 0
 
@@ -87,8 +139,10 @@ func scoreCell(x, y, w, h int, world [][]byte) byte {
 			// ensures we don't include the cell itself in our calculations
 			if !(i == y && j == x) {
 				// adds 1 if the current neighbour is alive, and 0 otherwise
-				// indexes are designed to handle wrapping around the world
-				score += (world[(h+i)%h][(w+j)%w] / 255)
+				// the topology decides what's past the edges of the world
+				if wrappedX, wrappedY, onWorld := topology.Wrap(j, i, w, h); onWorld {
+					score += (world[wrappedY][wrappedX] / 255)
+				}
 			}
 		}
 	}
@@ -121,28 +175,168 @@ func (g *GolWorker) LiveCellRequest(req stubs.Report, res *stubs.LiveCellsCount)
 	return
 }
 
-func (g *GolWorker) TakeTurn(req stubs.BoundaryUpdate, res *stubs.WorldResponse) (err error) {
-	turnChan <- req
+// rpc function called by a neighbouring worker to hand over one of our halo rows for the next turn
+// if there's no room the row must be stale, as we only ever have one outstanding halo per side, so it's dropped
+func (g *GolWorker) ReceiveBoundary(req stubs.BoundaryUpdate, res *stubs.Report) (err error) {
+	run := getRun()
+	if run == nil {
+		return
+	}
+	halo := run.bottomHalo
+	if req.Top {
+		halo = run.topHalo
+	}
+	select {
+	case halo <- req:
+	default:
+	}
+	return
+}
+
+// rpc function used by the broker to check if this worker is still alive
+func (g *GolWorker) Ping(req stubs.Report, res *stubs.Report) (err error) {
+	return
+}
+
+// rpc function for the broker to pull our strip of the world when it needs a snapshot
+func (g *GolWorker) GetStrip(req stubs.Report, res *stubs.WorldResponse) (err error) {
+	run := getRun()
+	if run == nil {
+		return errors.New("worker isn't running")
+	}
+	reply := make(chan *stubs.WorldResponse)
+	select {
+	case stripRequests <- reply:
+	case <-run.done:
+		return errors.New("worker isn't running")
+	}
+	*res = *<-reply
+	return
+}
+
+// rpc function for the broker to change cells in our strip, the runner makes the change before it does anything else,
+// so a snapshot taken after this returns includes it
+func (g *GolWorker) Edit(req stubs.EditRequest, res *stubs.Report) (err error) {
+	run := getRun()
+	if run == nil {
+		return errors.New("worker isn't running")
+	}
+	select {
+	case cellEdits <- req:
+	case <-run.done:
+		return errors.New("worker isn't running")
+	}
+	return
+}
+
+func (g *GolWorker) TakeTurn(req stubs.TurnRequest, res *stubs.TurnResponse) (err error) {
+	run := getRun()
+	if run == nil {
+		return errors.New("worker isn't running")
+	}
+	select {
+	case run.turns <- req:
+	case <-run.done:
+		return errors.New("worker isn't running")
+	}
+	// once the runner has taken the turn it always answers, even if it's aborted part way through
 	response := <-worldResponses
-	res.Liveness = 1
-	if len(response) == 0 {
-		res.Liveness = 2
+	// a nil response means the runner was aborted part way through the turn
+	if response == nil {
+		return errors.New("turn aborted")
 	}
-	res.LiveCells = encodeCells(response)
-	res.Turn = req.Turn + 1
+	*res = *response
 	return
 }
 
 func (g *GolWorker) StartWorker(req stubs.WorldDataBounded, res *stubs.Report) (err error) {
+	startLock.Lock()
+	defer startLock.Unlock()
+
+	// our edge rows are swapped with our neighbours every turn, so there has to be at least one
+	if req.Bottom <= req.Top {
+		return errors.New(fmt.Sprint("strip from row ", req.Top, " to ", req.Bottom, " is empty"))
+	}
+
+	// if the broker is reassigning strips we might still have a runner going, so stop it first
+	if previous := getRun(); previous != nil {
+		close(previous.abort)
+		<-previous.done
+	}
+
+	// a new run starts with empty halos, throwing away any left over from the previous one
+	run := &workerRun{
+		abort:      make(chan bool),
+		done:       make(chan bool),
+		turns:      make(chan stubs.TurnRequest),
+		topHalo:    make(chan stubs.BoundaryUpdate, 2),
+		bottomHalo: make(chan stubs.BoundaryUpdate, 2),
+	}
+	runLock.Lock()
+	currentRun = run
+	runLock.Unlock()
+
+	// the neighbouring workers, these can be the same worker, or this worker itself
+	// they're nil if our strip is at an edge of the world that doesn't wrap around
+	above, err := dialNeighbour(req.Above)
+	fmt.Println("Dialling above", err)
+	if err != nil {
+		close(run.done)
+		return
+	}
+	below, err := dialNeighbour(req.Below)
+	fmt.Println("Dialling below", err)
+	if err != nil {
+		if above != nil {
+			above.Close()
+		}
+		close(run.done)
+		return
+	}
+
 	setupDone := make(chan bool)
-	go GolRunner(req, setupDone)
+	go GolRunner(req, above, below, run.turns, run.topHalo, run.bottomHalo, run.abort, run.done, setupDone)
 	<-setupDone
 	return
 
 }
 
-func GolRunner(req stubs.WorldDataBounded, setupDone chan bool) {
-	world := req.Data.World
+// function to get the current run, so that it can't change under an rpc handler that's using it
+func getRun() *workerRun {
+	runLock.Lock()
+	defer runLock.Unlock()
+	return currentRun
+}
+
+// function to connect to a neighbouring worker, an empty address means there isn't one
+func dialNeighbour(address string) (*rpc.Client, error) {
+	if address == "" {
+		return nil, nil
+	}
+	return rpc.Dial("tcp", address)
+}
+
+// function to wait for the halo row y for the given turn, discarding anything stale that's still buffered
+// returns false if the runner is aborted while waiting
+func waitForHalo(halo chan stubs.BoundaryUpdate, y, turn int, abort chan bool) ([]byte, bool) {
+	for {
+		select {
+		case update := <-halo:
+			if update.Y == y && update.Turn == turn {
+				return update.Row, true
+			}
+		case <-abort:
+			return nil, false
+		}
+	}
+}
+
+func GolRunner(req stubs.WorldDataBounded, above, below *rpc.Client, turns chan stubs.TurnRequest,
+	topHalo, bottomHalo chan stubs.BoundaryUpdate, abort, runnerDone, setupDone chan bool) {
+	defer close(runnerDone)
+
+	world := req.Data.World.Unpack()
+	depth := req.Data.Rule.Depth()
 	top := req.Top
 	bottom := req.Bottom
 	fmt.Println(top, bottom)
@@ -150,7 +344,14 @@ func GolRunner(req stubs.WorldDataBounded, setupDone chan bool) {
 	bottomBound := (bottom) % req.Data.Height
 	fmt.Println(topBound, bottomBound)
 
-	var liveCells []util.Cell
+	// the broker can ask for a particular number of threads, otherwise we go with our own -threads flag
+	threads := defaultThreads
+	if req.Data.WorkerThreads > 0 {
+		threads = req.Data.WorkerThreads
+	}
+
+	// our strip as it was in the live view's last frame, so the next frame only needs what's changed since
+	var shown [][]byte
 
 	halt := false
 	close := false
@@ -159,27 +360,92 @@ func GolRunner(req stubs.WorldDataBounded, setupDone chan bool) {
 
 	for !(halt || close) {
 		select {
-		case bounds := <-turnChan:
+		case turnRequest := <-turns:
 			fmt.Println("Taking turn")
-			if bounds.Turn >= req.Data.Turn {
+			if turnRequest.Turn >= req.Data.Turn {
 				halt = true
 			}
 
-			world[topBound] = bounds.Top
-			world[bottomBound] = bounds.Bottom
-			if req.Data.Height == 16 && bounds.Turn <= 50 {
-				fmt.Println(bounds.Turn)
+			// our top row is the bottom halo of the worker above, and our bottom row is the top halo of the
+			// worker below, these only get buffered on the other end so it's safe to send both before receiving
+			// these are sent asynchronously so that a hung neighbour can't stop us from being aborted
+			if above != nil {
+				above.Go(stubs.ExchangeBoundary, stubs.BoundaryUpdate{Row: util.PackRow(world[top], depth), Y: top, Top: false, Turn: turnRequest.Turn}, &stubs.Report{}, nil)
+			}
+			if below != nil {
+				below.Go(stubs.ExchangeBoundary, stubs.BoundaryUpdate{Row: util.PackRow(world[bottom-1], depth), Y: bottom - 1, Top: true, Turn: turnRequest.Turn}, &stubs.Report{}, nil)
+			}
+
+			ok := true
+			if above != nil {
+				var topRow []byte
+				topRow, ok = waitForHalo(topHalo, topBound, turnRequest.Turn, abort)
+				if ok {
+					world[topBound] = util.UnpackRow(topRow, req.Data.Width, depth)
+				}
+			}
+			if ok && below != nil {
+				var bottomRow []byte
+				bottomRow, ok = waitForHalo(bottomHalo, bottomBound, turnRequest.Turn, abort)
+				if ok {
+					world[bottomBound] = util.UnpackRow(bottomRow, req.Data.Width, depth)
+				}
+			}
+			// let the broker know this turn isn't going to be finished
+			if !ok {
+				worldResponses <- nil
+				halt = true
+				break
+			}
+			if req.Data.Height == 16 && turnRequest.Turn <= 50 {
+				fmt.Println(turnRequest.Turn)
 				for y, row := range world {
 					fmt.Println(y, row)
 				}
 			}
 
-			newState := calculateNextState(world, req.Data.Width, req.Data.Height, top, bottom)
+			newState := calculateNextState(world, req.Data.Width, req.Data.Height, top, bottom, threads, req.Data.Rule, req.Data.Topology)
 
 			world = newState.World
-			liveCells = newState.LiveCells
 
-			worldResponses <- liveCells
+			// a resync sends the whole strip, which the frames after it are based on
+			var changed []uint32
+			var levels []byte
+			var strip util.PackedWorld
+			if turnRequest.Resync || (turnRequest.Changes && shown == nil) {
+				strip = util.PackWorld(world[top:bottom], depth)
+				shown = make([][]byte, bottom-top)
+				for y := range shown {
+					shown[y] = append([]byte{}, world[top+y]...)
+				}
+			} else if turnRequest.Changes {
+				changed, levels = findChanges(shown, world, top, bottom, depth)
+			}
+
+			// the world stays here, the broker only gets how many cells are alive
+			aliveCount := 0
+			for _, row := range world[top:bottom] {
+				for _, cell := range row {
+					if cell == 255 {
+						aliveCount++
+					}
+				}
+			}
+			worldResponses <- &stubs.TurnResponse{AliveCount: aliveCount, Turn: turnRequest.Turn + 1, Changed: changed, Levels: levels, Strip: strip}
+		case reply := <-stripRequests:
+			reply <- &stubs.WorldResponse{World: util.PackWorld(world[top:bottom], depth), Top: top}
+		// cells outside our strip are someone else's, and worlds without grey levels only have alive and dead
+		case edit := <-cellEdits:
+			for i, cell := range edit.Cells {
+				if cell.Y < top || cell.Y >= bottom || cell.X < 0 || cell.X >= req.Data.Width {
+					continue
+				}
+				level := edit.Levels[i]
+				if depth == 1 && level != 0 {
+					level = 255
+				}
+				world[cell.Y][cell.X] = level
+			}
 		case key := <-keyPresses:
 			switch key {
 			case 'q':
@@ -187,9 +453,17 @@ func GolRunner(req stubs.WorldDataBounded, setupDone chan bool) {
 			case 'k':
 				close = true
 			}
+		case <-abort:
+			halt = true
 		}
 
 	}
+	if above != nil {
+		above.Close()
+	}
+	if below != nil {
+		below.Close()
+	}
 	if close {
 		stopRunning <- true
 	}
@@ -200,11 +474,27 @@ func GolRunner(req stubs.WorldDataBounded, setupDone chan bool) {
 func main() {
 	// setting up rpc calls
 	pAddr := flag.String("port", "8030", "Port to listen on")
+	brokerAddr := flag.String("broker", "", "Address of a broker to register with")
+	ipAddr := flag.String("ip", "", "Address the broker should use to reach this worker, defaults to 127.0.0.1:port")
+	flag.IntVar(&defaultThreads, "threads", runtime.NumCPU(), "Number of goroutines to split each turn between")
 	flag.Parse()
 
 	rpc.Register(&GolWorker{})
 	listener, _ := net.Listen("tcp", ":"+*pAddr)
 	go rpc.Accept(listener)
+
+	// joining the broker's pool, this has to happen after we start listening as the broker dials back
+	if *brokerAddr != "" {
+		if *ipAddr == "" {
+			*ipAddr = "127.0.0.1:" + *pAddr
+		}
+		broker, err := rpc.Dial("tcp", *brokerAddr)
+		if err == nil {
+			err = broker.Call(stubs.RegisterWorker, stubs.WorkerInfo{WorkerIP: *ipAddr}, &stubs.Report{})
+			broker.Close()
+		}
+		fmt.Println("Registering with broker", err)
+	}
 	<-stopRunning
 	time.Sleep(1 * time.Second)
 }